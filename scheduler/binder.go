@@ -2,22 +2,37 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
+// BindPod runs the PreBind plugins of the pod's profile and binds the pod to
+// the node, through a binder extender of the profile when there is one
 func BindPod(clientset kubernetes.Interface, pod *v1.Pod, nodeName string) error {
 	binding := &v1.Binding{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      pod.Name,
 			Namespace: pod.Namespace,
+			UID:       pod.UID,
 		},
 		Target: v1.ObjectReference{
 			Kind: "Node",
 			Name: nodeName,
 		},
 	}
+	fwk, err := frameworkForPod(pod)
+	if err != nil {
+		return err
+	}
+	if err := fwk.RunPreBindPlugins(&CycleState{ClientSet: clientset}, pod, nodeName); err != nil {
+		return err
+	}
+	if ext := fwk.binderForPod(pod); ext != nil {
+		fmt.Printf("Binding pod %s through extender %s\n", pod.Name, ext.Name())
+		return ext.Bind(binding)
+	}
 	return clientset.CoreV1().Pods(pod.Namespace).Bind(context.TODO(), binding, metav1.CreateOptions{})
 }
@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// recordPodEvent creates an event for the pod, visible with kubectl describe pod
+func recordPodEvent(clientset kubernetes.Interface, pod *v1.Pod, eventType, reason, message string) error {
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pod.Name + ".",
+			Namespace:    pod.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:      "Pod",
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			UID:       pod.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: SchedulerName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	_, err := clientset.CoreV1().Events(pod.Namespace).Create(context.TODO(), event, metav1.CreateOptions{})
+	return err
+}
+
+// recordSchedulingFailure sets the PodScheduled condition of the pod to False with
+// the reason scheduling failed and emits a FailedScheduling event. Nothing is
+// written when the pod already reports the same message.
+func recordSchedulingFailure(clientset kubernetes.Interface, pod *v1.Pod, schedErr error) {
+	message := schedErr.Error()
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodScheduled && cond.Status == v1.ConditionFalse && cond.Message == message {
+			return
+		}
+	}
+
+	condition := v1.PodCondition{
+		Type:               v1.PodScheduled,
+		Status:             v1.ConditionFalse,
+		Reason:             v1.PodReasonUnschedulable,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []v1.PodCondition{condition},
+		},
+	})
+	if err != nil {
+		fmt.Printf("Failed to build condition patch for pod %s: %v\n", pod.Name, err)
+		return
+	}
+	_, err = clientset.CoreV1().Pods(pod.Namespace).Patch(
+		context.TODO(),
+		pod.Name,
+		types.StrategicMergePatchType,
+		patch,
+		metav1.PatchOptions{},
+		"status",
+	)
+	if err != nil {
+		fmt.Printf("Failed to update PodScheduled condition of pod %s: %v\n", pod.Name, err)
+	}
+	if err := recordPodEvent(clientset, pod, v1.EventTypeWarning, "FailedScheduling", message); err != nil {
+		fmt.Printf("Failed to record event for pod %s: %v\n", pod.Name, err)
+	}
+}
+
+// Pods already reported as past their deadline, keyed by namespace/name
+var deadlineExceededReported = map[string]bool{}
+
+// recordDeadlineExceeded emits a DeadlineExceeded event, once, for every pending
+// pod whose deadline annotation has passed. Pods that are no longer pending are
+// forgotten so the map doesn't grow.
+func recordDeadlineExceeded(clientset kubernetes.Interface, pods []*v1.Pod, now time.Time) {
+	pending := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		key := podKey(pod)
+		pending[key] = true
+		deadline := getPodDeadline(pod)
+		if deadline == nil || !now.After(*deadline) || deadlineExceededReported[key] {
+			continue
+		}
+		message := fmt.Sprintf("Pod was not scheduled before its deadline %s", deadline.Format(time.RFC3339))
+		if err := recordPodEvent(clientset, pod, v1.EventTypeWarning, "DeadlineExceeded", message); err != nil {
+			fmt.Printf("Failed to record event for pod %s: %v\n", pod.Name, err)
+			continue
+		}
+		deadlineExceededReported[key] = true
+	}
+	for key := range deadlineExceededReported {
+		if !pending[key] {
+			delete(deadlineExceededReported, key)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package extender
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// DefaultHTTPTimeout is used when a Config does not set HTTPTimeout
+const DefaultHTTPTimeout = 5 * time.Second
+
+// Config describes an extender and which verbs it implements. A verb left
+// empty is not called.
+type Config struct {
+	// URLPrefix is the base URL the verbs are appended to, e.g. http://127.0.0.1:8888/scheduler
+	URLPrefix      string
+	FilterVerb     string
+	PrioritizeVerb string
+	BindVerb       string
+	PreemptVerb    string
+	// Weight multiplies the prioritize scores, required when PrioritizeVerb is set
+	Weight      int64
+	HTTPTimeout time.Duration
+	// NodeCacheCapable extenders get node names instead of full node objects
+	NodeCacheCapable bool
+	// ManagedResources limits the extender to pods requesting one of these
+	// resources. When empty every pod is sent to the extender.
+	ManagedResources []string
+	// Ignorable extenders are skipped when they fail or can't be reached
+	Ignorable bool
+}
+
+// HTTPExtender calls an extender over HTTP with JSON bodies
+type HTTPExtender struct {
+	config Config
+	client *http.Client
+}
+
+// NewHTTPExtender validates the config and builds the client for it
+func NewHTTPExtender(config Config) (*HTTPExtender, error) {
+	if config.URLPrefix == "" {
+		return nil, fmt.Errorf("extender URLPrefix is required")
+	}
+	if config.PrioritizeVerb != "" && config.Weight <= 0 {
+		return nil, fmt.Errorf("extender %s has prioritize verb but weight %d, must be positive", config.URLPrefix, config.Weight)
+	}
+	if config.HTTPTimeout == 0 {
+		config.HTTPTimeout = DefaultHTTPTimeout
+	}
+	return &HTTPExtender{
+		config: config,
+		client: &http.Client{Timeout: config.HTTPTimeout},
+	}, nil
+}
+
+// Name identifies the extender in logs and errors
+func (e *HTTPExtender) Name() string { return e.config.URLPrefix }
+
+func (e *HTTPExtender) IsIgnorable() bool { return e.config.Ignorable }
+
+func (e *HTTPExtender) IsBinder() bool { return e.config.BindVerb != "" }
+
+func (e *HTTPExtender) SupportsPreemption() bool { return e.config.PreemptVerb != "" }
+
+func (e *HTTPExtender) Weight() int64 { return e.config.Weight }
+
+// IsInterested reports whether the pod requests any resource the extender manages
+func (e *HTTPExtender) IsInterested(pod *v1.Pod) bool {
+	if len(e.config.ManagedResources) == 0 {
+		return true
+	}
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		for _, name := range e.config.ManagedResources {
+			if _, ok := c.Resources.Requests[v1.ResourceName(name)]; ok {
+				return true
+			}
+			if _, ok := c.Resources.Limits[v1.ResourceName(name)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Filter sends the nodes to the filter verb and returns the ones the extender
+// accepts, with the reasons for the rejected ones
+func (e *HTTPExtender) Filter(pod *v1.Pod, nodes []*v1.Node) ([]*v1.Node, FailedNodesMap, FailedNodesMap, error) {
+	if e.config.FilterVerb == "" {
+		return nodes, FailedNodesMap{}, FailedNodesMap{}, nil
+	}
+	byName := make(map[string]*v1.Node, len(nodes))
+	for _, node := range nodes {
+		byName[node.Name] = node
+	}
+
+	args := e.buildArgs(pod, nodes)
+	var result ExtenderFilterResult
+	if err := e.send(e.config.FilterVerb, args, &result); err != nil {
+		return nil, nil, nil, err
+	}
+	if result.Error != "" {
+		return nil, nil, nil, fmt.Errorf("extender %s filter: %s", e.Name(), result.Error)
+	}
+
+	var filtered []*v1.Node
+	if e.config.NodeCacheCapable && result.NodeNames != nil {
+		for _, name := range *result.NodeNames {
+			node, ok := byName[name]
+			if !ok {
+				return nil, nil, nil, fmt.Errorf("extender %s returned unknown node %q", e.Name(), name)
+			}
+			filtered = append(filtered, node)
+		}
+	} else if result.Nodes != nil {
+		for i := range result.Nodes.Items {
+			node, ok := byName[result.Nodes.Items[i].Name]
+			if !ok {
+				return nil, nil, nil, fmt.Errorf("extender %s returned unknown node %q", e.Name(), result.Nodes.Items[i].Name)
+			}
+			filtered = append(filtered, node)
+		}
+	}
+	if result.FailedNodes == nil {
+		result.FailedNodes = FailedNodesMap{}
+	}
+	if result.FailedAndUnresolvableNodes == nil {
+		result.FailedAndUnresolvableNodes = FailedNodesMap{}
+	}
+	return filtered, result.FailedNodes, result.FailedAndUnresolvableNodes, nil
+}
+
+// Prioritize sends the nodes to the prioritize verb and returns the scores,
+// between 0 and MaxExtenderPriority, and the weight to apply to them
+func (e *HTTPExtender) Prioritize(pod *v1.Pod, nodes []*v1.Node) (HostPriorityList, int64, error) {
+	if e.config.PrioritizeVerb == "" {
+		result := make(HostPriorityList, 0, len(nodes))
+		for _, node := range nodes {
+			result = append(result, HostPriority{Host: node.Name})
+		}
+		return result, 0, nil
+	}
+
+	args := e.buildArgs(pod, nodes)
+	var result HostPriorityList
+	if err := e.send(e.config.PrioritizeVerb, args, &result); err != nil {
+		return nil, 0, err
+	}
+	return result, e.config.Weight, nil
+}
+
+// Bind asks the extender to bind the pod to the node
+func (e *HTTPExtender) Bind(binding *v1.Binding) error {
+	if e.config.BindVerb == "" {
+		return fmt.Errorf("extender %s has no bind verb", e.Name())
+	}
+	args := &ExtenderBindingArgs{
+		PodName:      binding.Name,
+		PodNamespace: binding.Namespace,
+		PodUID:       binding.UID,
+		Node:         binding.Target.Name,
+	}
+	var result ExtenderBindingResult
+	if err := e.send(e.config.BindVerb, args, &result); err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return fmt.Errorf("extender %s bind: %s", e.Name(), result.Error)
+	}
+	return nil
+}
+
+// ProcessPreemption sends the planned victims per node to the preempt verb and
+// returns the victims the extender agrees with. Nodes it drops can't be used.
+func (e *HTTPExtender) ProcessPreemption(pod *v1.Pod, nodeNameToVictims map[string]*Victims) (map[string]*Victims, error) {
+	if e.config.PreemptVerb == "" {
+		return nodeNameToVictims, nil
+	}
+
+	args := &ExtenderPreemptionArgs{Pod: pod}
+	if e.config.NodeCacheCapable {
+		args.NodeNameToMetaVictims = convertToMetaVictims(nodeNameToVictims)
+	} else {
+		args.NodeNameToVictims = nodeNameToVictims
+	}
+
+	var result ExtenderPreemptionResult
+	if err := e.send(e.config.PreemptVerb, args, &result); err != nil {
+		return nil, err
+	}
+
+	// Map the returned UIDs back to the pods we sent
+	victims := map[string]*Victims{}
+	for nodeName, metaVictims := range result.NodeNameToMetaVictims {
+		sent, ok := nodeNameToVictims[nodeName]
+		if !ok {
+			return nil, fmt.Errorf("extender %s returned unknown node %q for preemption", e.Name(), nodeName)
+		}
+		byUID := map[string]*v1.Pod{}
+		for _, p := range sent.Pods {
+			byUID[string(p.UID)] = p
+		}
+		v := &Victims{NumPDBViolations: metaVictims.NumPDBViolations}
+		for _, mp := range metaVictims.Pods {
+			p, ok := byUID[mp.UID]
+			if !ok {
+				return nil, fmt.Errorf("extender %s returned unknown victim %q on node %s", e.Name(), mp.UID, nodeName)
+			}
+			v.Pods = append(v.Pods, p)
+		}
+		victims[nodeName] = v
+	}
+	return victims, nil
+}
+
+// Helper to build the filter and prioritize request, with node names only for
+// node cache capable extenders
+func (e *HTTPExtender) buildArgs(pod *v1.Pod, nodes []*v1.Node) *ExtenderArgs {
+	args := &ExtenderArgs{Pod: pod}
+	if e.config.NodeCacheCapable {
+		names := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			names = append(names, node.Name)
+		}
+		args.NodeNames = &names
+		return args
+	}
+	list := &v1.NodeList{}
+	for _, node := range nodes {
+		list.Items = append(list.Items, *node)
+	}
+	args.Nodes = list
+	return args
+}
+
+// Helper to replace the victim pods by their UIDs
+func convertToMetaVictims(nodeNameToVictims map[string]*Victims) map[string]*MetaVictims {
+	result := map[string]*MetaVictims{}
+	for nodeName, victims := range nodeNameToVictims {
+		meta := &MetaVictims{NumPDBViolations: victims.NumPDBViolations}
+		for _, p := range victims.Pods {
+			meta.Pods = append(meta.Pods, &MetaPod{UID: string(p.UID)})
+		}
+		result[nodeName] = meta
+	}
+	return result
+}
+
+// Helper to POST args as JSON to the verb and decode the response into result
+func (e *HTTPExtender) send(verb string, args interface{}, result interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimRight(e.config.URLPrefix, "/") + "/" + verb
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed %s with extender at URL %s, code %d", verb, url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,89 @@
+package extender
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The types below mirror k8s.io/kube-scheduler/extender/v1, which declares no
+// json tags, so fields go over the wire under their Go names (Pod, NodeNames,
+// Host, Score, ...) and existing extenders work unchanged.
+
+// MaxExtenderPriority is the highest score an extender returns from prioritize
+const MaxExtenderPriority int64 = 10
+
+// ExtenderArgs is sent to the filter and prioritize verbs
+type ExtenderArgs struct {
+	Pod *v1.Pod
+	// Full node objects, sent when the extender is not node cache capable
+	Nodes *v1.NodeList
+	// Node names only, sent when the extender is node cache capable
+	NodeNames *[]string
+}
+
+// FailedNodesMap maps a node name to the reason it was filtered out
+type FailedNodesMap map[string]string
+
+// ExtenderFilterResult is the response of the filter verb
+type ExtenderFilterResult struct {
+	Nodes     *v1.NodeList
+	NodeNames *[]string
+	// Nodes that may fit after preemption
+	FailedNodes FailedNodesMap
+	// Nodes that will not fit even if pods are preempted
+	FailedAndUnresolvableNodes FailedNodesMap
+	Error                      string
+}
+
+// HostPriority is the score of a single node returned by the prioritize verb
+type HostPriority struct {
+	Host  string
+	Score int64
+}
+
+// HostPriorityList is the response of the prioritize verb
+type HostPriorityList []HostPriority
+
+// ExtenderBindingArgs is sent to the bind verb
+type ExtenderBindingArgs struct {
+	PodName      string
+	PodNamespace string
+	PodUID       types.UID
+	Node         string
+}
+
+// ExtenderBindingResult is the response of the bind verb
+type ExtenderBindingResult struct {
+	Error string
+}
+
+// Victims are the pods to preempt on a node
+type Victims struct {
+	Pods             []*v1.Pod
+	NumPDBViolations int64
+}
+
+// MetaPod identifies a pod by UID
+type MetaPod struct {
+	UID string
+}
+
+// MetaVictims are the pods to preempt on a node, identified by UID
+type MetaVictims struct {
+	Pods             []*MetaPod
+	NumPDBViolations int64
+}
+
+// ExtenderPreemptionArgs is sent to the preempt verb
+type ExtenderPreemptionArgs struct {
+	Pod *v1.Pod
+	// Victims per node, sent when the extender is not node cache capable
+	NodeNameToVictims map[string]*Victims
+	// Victim UIDs per node, sent when the extender is node cache capable
+	NodeNameToMetaVictims map[string]*MetaVictims
+}
+
+// ExtenderPreemptionResult is the response of the preempt verb
+type ExtenderPreemptionResult struct {
+	NodeNameToMetaVictims map[string]*MetaVictims
+}
@@ -0,0 +1,566 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"sample-k8-scheduler/scheduler/extender"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// SchedulerName is the spec.schedulerName handled by this scheduler and the
+// name of the default profile
+const SchedulerName = "kubescheduler"
+
+// MaxNodeScore is the highest score a score plugin should return after normalization
+const MaxNodeScore int64 = 100
+
+// Plugin is the parent type of all scheduling framework plugins
+type Plugin interface {
+	Name() string
+}
+
+// FilterPlugin rules out nodes that cannot run the pod. A non-nil error is the
+// reason the node was filtered out.
+type FilterPlugin interface {
+	Plugin
+	Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error
+}
+
+// ScorePlugin ranks nodes that passed filtering
+type ScorePlugin interface {
+	Plugin
+	Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, error)
+}
+
+// PreFilterPlugin can be implemented by a FilterPlugin to compute state once per
+// scheduling attempt before any node is filtered. An error makes the pod unschedulable.
+type PreFilterPlugin interface {
+	PreFilter(state *CycleState, pod *v1.Pod) error
+}
+
+// PreScorePlugin can be implemented by a ScorePlugin to compute state once per
+// scheduling attempt from the nodes that passed filtering
+type PreScorePlugin interface {
+	PreScore(state *CycleState, pod *v1.Pod, nodeInfos []*NodeInfo) error
+}
+
+// PreBindPlugin can be implemented by a FilterPlugin to update the cluster
+// for the chosen node before the pod is bound, such as binding its volumes.
+// An error keeps the pod from being bound.
+type PreBindPlugin interface {
+	PreBind(state *CycleState, pod *v1.Pod, nodeName string) error
+}
+
+// ScoreNormalizer can be implemented by a ScorePlugin to rescale its raw scores
+// into [0, MaxNodeScore] before weights are applied
+type ScoreNormalizer interface {
+	NormalizeScore(state *CycleState, pod *v1.Pod, scores NodeScoreList) error
+}
+
+// CycleState carries data shared by plugins during one scheduling attempt
+type CycleState struct {
+	ClientSet kubernetes.Interface
+	// All nodes in the cluster, not only the ones that passed filtering
+	NodeInfos []*NodeInfo
+	data      map[string]interface{}
+}
+
+// Read returns the data a plugin stored under key during this cycle
+func (s *CycleState) Read(key string) (interface{}, bool) {
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// Write stores plugin data under key for the rest of this cycle
+func (s *CycleState) Write(key string, v interface{}) {
+	if s.data == nil {
+		s.data = map[string]interface{}{}
+	}
+	s.data[key] = v
+}
+
+// NodeScore is the score of a single node
+type NodeScore struct {
+	Name  string
+	Score int64
+}
+
+type NodeScoreList []NodeScore
+
+// FitError describes why a pod could not be placed on any node
+type FitError struct {
+	Pod         *v1.Pod
+	NumAllNodes int
+	// Reason each node was filtered out, keyed by node name
+	NodeReasons map[string]string
+}
+
+func (e *FitError) Error() string {
+	counts := map[string]int{}
+	for _, reason := range e.NodeReasons {
+		counts[reason]++
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason, count := range counts {
+		reasons = append(reasons, fmt.Sprintf("%d %s", count, reason))
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("0/%d nodes are available: %s", e.NumAllNodes, strings.Join(reasons, ", "))
+}
+
+// PluginFactory builds a plugin instance for a profile
+type PluginFactory func() (Plugin, error)
+
+// Registry maps plugin names to their factories
+type Registry map[string]PluginFactory
+
+// registry holds the in-tree plugins and any plugin added with RegisterPlugin
+var registry = Registry{
+	NodeConditionsName:    func() (Plugin, error) { return &NodeConditions{}, nil },
+	NodeUnschedulableName: func() (Plugin, error) { return &NodeUnschedulable{}, nil },
+	TaintTolerationName:   func() (Plugin, error) { return &TaintToleration{}, nil },
+	NodeAffinityName:      func() (Plugin, error) { return &NodeAffinity{}, nil },
+	NodeResourcesFitName:  func() (Plugin, error) { return &NodeResourcesFit{}, nil },
+	NodePortsName:         func() (Plugin, error) { return &NodePorts{}, nil },
+	InterPodAffinityName:  func() (Plugin, error) { return &InterPodAffinity{}, nil },
+	PodTopologySpreadName: func() (Plugin, error) { return &PodTopologySpread{}, nil },
+	VolumeBindingName:     func() (Plugin, error) { return &VolumeBinding{}, nil },
+	NodeVolumeLimitsName:  func() (Plugin, error) { return &NodeVolumeLimits{}, nil },
+	ImageLocalityName:     func() (Plugin, error) { return &ImageLocality{}, nil },
+}
+
+// RegisterPlugin adds an out-of-tree plugin so it can be enabled in a profile
+func RegisterPlugin(name string, factory PluginFactory) error {
+	if _, exists := registry[name]; exists {
+		return fmt.Errorf("plugin %q is already registered", name)
+	}
+	registry[name] = factory
+	return nil
+}
+
+// PluginConfig enables a plugin in a profile. Weight is only used for score plugins.
+type PluginConfig struct {
+	Name   string
+	Weight int64
+}
+
+// Profile lists the plugins used for pods with a given spec.schedulerName
+type Profile struct {
+	SchedulerName string
+	Filter        []PluginConfig
+	Score         []PluginConfig
+	// Extenders are called over HTTP after the filter and score plugins
+	Extenders []extender.Config
+}
+
+// DefaultProfile is used for pods whose scheduler name has no profile of its own
+var DefaultProfile = Profile{
+	SchedulerName: SchedulerName,
+	Filter: []PluginConfig{
+		{Name: NodeConditionsName},
+		{Name: NodeUnschedulableName},
+		{Name: TaintTolerationName},
+		{Name: NodeAffinityName},
+		{Name: NodePortsName},
+		{Name: NodeResourcesFitName},
+		{Name: InterPodAffinityName},
+		{Name: PodTopologySpreadName},
+		{Name: VolumeBindingName},
+		{Name: NodeVolumeLimitsName},
+	},
+	Score: []PluginConfig{
+		{Name: NodeResourcesFitName, Weight: 1},
+		{Name: InterPodAffinityName, Weight: 2},
+		{Name: PodTopologySpreadName, Weight: 2},
+		{Name: ImageLocalityName, Weight: 1},
+	},
+}
+
+// profiles holds the framework built for each scheduler name
+var profiles = map[string]*Framework{}
+
+// AddProfile builds the framework for a profile and makes it available to pods
+// with a matching spec.schedulerName. An existing profile with the same name is replaced.
+func AddProfile(profile Profile) error {
+	fwk, err := NewFramework(registry, profile)
+	if err != nil {
+		return err
+	}
+	profiles[profile.SchedulerName] = fwk
+	return nil
+}
+
+// ExtendersConfigEnv names the environment variable holding the path of the
+// file Start loads the default profile's extenders from
+const ExtendersConfigEnv = "SCHEDULER_EXTENDERS_CONFIG"
+
+// extendersFile is the YAML or JSON file of extenders, with the field names
+// of the extenders section of a KubeSchedulerConfiguration
+type extendersFile struct {
+	Extenders []struct {
+		URLPrefix        string          `json:"urlPrefix"`
+		FilterVerb       string          `json:"filterVerb"`
+		PrioritizeVerb   string          `json:"prioritizeVerb"`
+		BindVerb         string          `json:"bindVerb"`
+		PreemptVerb      string          `json:"preemptVerb"`
+		Weight           int64           `json:"weight"`
+		HTTPTimeout      metav1.Duration `json:"httpTimeout"`
+		NodeCacheCapable bool            `json:"nodeCacheCapable"`
+		ManagedResources []struct {
+			Name string `json:"name"`
+		} `json:"managedResources"`
+		Ignorable bool `json:"ignorable"`
+	} `json:"extenders"`
+}
+
+// LoadExtenders reads the extenders file at path into the default profile
+// and rebuilds it, so a bad extender is reported before scheduling starts
+func LoadExtenders(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file extendersFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return fmt.Errorf("parsing extenders file %s: %v", path, err)
+	}
+	configs := make([]extender.Config, 0, len(file.Extenders))
+	for _, e := range file.Extenders {
+		config := extender.Config{
+			URLPrefix:        e.URLPrefix,
+			FilterVerb:       e.FilterVerb,
+			PrioritizeVerb:   e.PrioritizeVerb,
+			BindVerb:         e.BindVerb,
+			PreemptVerb:      e.PreemptVerb,
+			Weight:           e.Weight,
+			HTTPTimeout:      e.HTTPTimeout.Duration,
+			NodeCacheCapable: e.NodeCacheCapable,
+			Ignorable:        e.Ignorable,
+		}
+		for _, r := range e.ManagedResources {
+			config.ManagedResources = append(config.ManagedResources, r.Name)
+		}
+		configs = append(configs, config)
+	}
+	profile := DefaultProfile
+	profile.Extenders = configs
+	if err := AddProfile(profile); err != nil {
+		return err
+	}
+	DefaultProfile = profile
+	return nil
+}
+
+// hasProfile reports whether pods with this schedulerName are scheduled by us
+func hasProfile(schedulerName string) bool {
+	_, ok := profiles[schedulerName]
+	return ok || schedulerName == SchedulerName
+}
+
+// Helper to find the framework for a pod, falling back to the default profile
+func frameworkForPod(pod *v1.Pod) (*Framework, error) {
+	if fwk, ok := profiles[pod.Spec.SchedulerName]; ok {
+		return fwk, nil
+	}
+	if fwk, ok := profiles[SchedulerName]; ok {
+		return fwk, nil
+	}
+	if err := AddProfile(DefaultProfile); err != nil {
+		return nil, err
+	}
+	return profiles[SchedulerName], nil
+}
+
+type weightedScorePlugin struct {
+	plugin ScorePlugin
+	weight int64
+}
+
+// Framework runs the plugins enabled in a profile
+type Framework struct {
+	profileName   string
+	filterPlugins []FilterPlugin
+	scorePlugins  []weightedScorePlugin
+	extenders     []*extender.HTTPExtender
+}
+
+// NewFramework instantiates the plugins of a profile from the registry
+func NewFramework(r Registry, profile Profile) (*Framework, error) {
+	fwk := &Framework{profileName: profile.SchedulerName}
+	instances := map[string]Plugin{}
+	getPlugin := func(name string) (Plugin, error) {
+		if p, ok := instances[name]; ok {
+			return p, nil
+		}
+		factory, ok := r[name]
+		if !ok {
+			return nil, fmt.Errorf("plugin %q is not registered", name)
+		}
+		p, err := factory()
+		if err != nil {
+			return nil, fmt.Errorf("initializing plugin %q: %v", name, err)
+		}
+		instances[name] = p
+		return p, nil
+	}
+
+	for _, cfg := range profile.Filter {
+		p, err := getPlugin(cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		filter, ok := p.(FilterPlugin)
+		if !ok {
+			return nil, fmt.Errorf("plugin %q does not implement FilterPlugin", cfg.Name)
+		}
+		fwk.filterPlugins = append(fwk.filterPlugins, filter)
+	}
+	for _, cfg := range profile.Score {
+		p, err := getPlugin(cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		score, ok := p.(ScorePlugin)
+		if !ok {
+			return nil, fmt.Errorf("plugin %q does not implement ScorePlugin", cfg.Name)
+		}
+		weight := cfg.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("score plugin %q has negative weight %d", cfg.Name, weight)
+		}
+		fwk.scorePlugins = append(fwk.scorePlugins, weightedScorePlugin{plugin: score, weight: weight})
+	}
+	for _, cfg := range profile.Extenders {
+		ext, err := extender.NewHTTPExtender(cfg)
+		if err != nil {
+			return nil, err
+		}
+		fwk.extenders = append(fwk.extenders, ext)
+	}
+	return fwk, nil
+}
+
+// RunPreFilterPlugins runs PreFilter of every filter plugin that implements it
+func (f *Framework) RunPreFilterPlugins(state *CycleState, pod *v1.Pod) error {
+	for _, p := range f.filterPlugins {
+		if pre, ok := p.(PreFilterPlugin); ok {
+			if err := pre.PreFilter(state, pod); err != nil {
+				return fmt.Errorf("plugin %q prefilter failed: %v", p.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// RunPreBindPlugins runs PreBind of every filter plugin that implements it
+func (f *Framework) RunPreBindPlugins(state *CycleState, pod *v1.Pod, nodeName string) error {
+	for _, p := range f.filterPlugins {
+		if pre, ok := p.(PreBindPlugin); ok {
+			if err := pre.PreBind(state, pod, nodeName); err != nil {
+				return fmt.Errorf("plugin %q prebind failed: %v", p.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// RunFilterPlugins returns the reason of the first filter plugin that rejects the node
+func (f *Framework) RunFilterPlugins(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	for _, p := range f.filterPlugins {
+		if err := p.Filter(state, pod, nodeInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunScorePlugins scores every node with every score plugin, normalizes each
+// plugin's scores and returns the weighted sum per node
+func (f *Framework) RunScorePlugins(state *CycleState, pod *v1.Pod, nodeInfos []*NodeInfo) (NodeScoreList, error) {
+	total := make(NodeScoreList, len(nodeInfos))
+	for i, info := range nodeInfos {
+		total[i].Name = info.Node.Name
+	}
+	for _, wp := range f.scorePlugins {
+		if pre, ok := wp.plugin.(PreScorePlugin); ok {
+			if err := pre.PreScore(state, pod, nodeInfos); err != nil {
+				return nil, fmt.Errorf("plugin %q prescore failed: %v", wp.plugin.Name(), err)
+			}
+		}
+	}
+	for _, wp := range f.scorePlugins {
+		scores := make(NodeScoreList, len(nodeInfos))
+		for i, info := range nodeInfos {
+			score, err := wp.plugin.Score(state, pod, info)
+			if err != nil {
+				return nil, fmt.Errorf("plugin %q failed to score node %s: %v", wp.plugin.Name(), info.Node.Name, err)
+			}
+			scores[i] = NodeScore{Name: info.Node.Name, Score: score}
+		}
+		if normalizer, ok := wp.plugin.(ScoreNormalizer); ok {
+			if err := normalizer.NormalizeScore(state, pod, scores); err != nil {
+				return nil, fmt.Errorf("plugin %q failed to normalize scores: %v", wp.plugin.Name(), err)
+			}
+		}
+		for i := range scores {
+			if scores[i].Score < 0 || scores[i].Score > MaxNodeScore {
+				return nil, fmt.Errorf("plugin %q returned score %d for node %s outside [0, %d]",
+					wp.plugin.Name(), scores[i].Score, scores[i].Name, MaxNodeScore)
+			}
+			total[i].Score += scores[i].Score * wp.weight
+		}
+	}
+	return total, nil
+}
+
+// RunExtenderFilters passes the nodes through the filter verb of every extender
+// interested in the pod and records the reasons of rejected nodes. Failing
+// extenders are skipped when ignorable.
+func (f *Framework) RunExtenderFilters(pod *v1.Pod, nodeInfos []*NodeInfo, fitErr *FitError) ([]*NodeInfo, error) {
+	for _, ext := range f.extenders {
+		if len(nodeInfos) == 0 {
+			break
+		}
+		if !ext.IsInterested(pod) {
+			continue
+		}
+		nodes := make([]*v1.Node, len(nodeInfos))
+		for i, info := range nodeInfos {
+			nodes[i] = info.Node
+		}
+		filtered, failed, failedAndUnresolvable, err := ext.Filter(pod, nodes)
+		if err != nil {
+			if ext.IsIgnorable() {
+				fmt.Printf("Skipping ignorable extender %s: %v\n", ext.Name(), err)
+				continue
+			}
+			return nil, err
+		}
+		for name, reason := range failed {
+			fitErr.NodeReasons[name] = reason
+		}
+		for name, reason := range failedAndUnresolvable {
+			fitErr.NodeReasons[name] = reason
+		}
+		kept := map[string]bool{}
+		for _, node := range filtered {
+			kept[node.Name] = true
+		}
+		remaining := make([]*NodeInfo, 0, len(filtered))
+		for _, info := range nodeInfos {
+			if kept[info.Node.Name] {
+				remaining = append(remaining, info)
+				continue
+			}
+			if _, ok := fitErr.NodeReasons[info.Node.Name]; !ok {
+				fitErr.NodeReasons[info.Node.Name] = fmt.Sprintf("node(s) were filtered out by extender %s", ext.Name())
+			}
+		}
+		nodeInfos = remaining
+	}
+	return nodeInfos, nil
+}
+
+// RunExtenderPrioritize adds the weighted prioritize scores of every interested
+// extender to scores, scaled from MaxExtenderPriority to MaxNodeScore. Errors are
+// logged and the extender's scores are left out, like upstream.
+func (f *Framework) RunExtenderPrioritize(pod *v1.Pod, nodeInfos []*NodeInfo, scores NodeScoreList) {
+	index := make(map[string]int, len(scores))
+	for i := range scores {
+		index[scores[i].Name] = i
+	}
+	nodes := make([]*v1.Node, len(nodeInfos))
+	for i, info := range nodeInfos {
+		nodes[i] = info.Node
+	}
+	for _, ext := range f.extenders {
+		if !ext.IsInterested(pod) {
+			continue
+		}
+		priorities, weight, err := ext.Prioritize(pod, nodes)
+		if err != nil {
+			fmt.Printf("Extender %s prioritize failed: %v\n", ext.Name(), err)
+			continue
+		}
+		for _, hp := range priorities {
+			if i, ok := index[hp.Host]; ok {
+				scores[i].Score += hp.Score * weight * (MaxNodeScore / extender.MaxExtenderPriority)
+			}
+		}
+	}
+}
+
+// RunExtenderPreemption passes the planned victims per node through the preempt
+// verb of every interested extender and returns the victims all of them accept.
+// Failing extenders are skipped when ignorable.
+func (f *Framework) RunExtenderPreemption(pod *v1.Pod, nodeNameToVictims map[string]*extender.Victims) (map[string]*extender.Victims, error) {
+	for _, ext := range f.extenders {
+		if len(nodeNameToVictims) == 0 {
+			break
+		}
+		if !ext.SupportsPreemption() || !ext.IsInterested(pod) {
+			continue
+		}
+		victims, err := ext.ProcessPreemption(pod, nodeNameToVictims)
+		if err != nil {
+			if ext.IsIgnorable() {
+				fmt.Printf("Skipping ignorable extender %s: %v\n", ext.Name(), err)
+				continue
+			}
+			return nil, err
+		}
+		nodeNameToVictims = victims
+	}
+	return nodeNameToVictims, nil
+}
+
+// binderForPod returns the first extender interested in the pod that binds pods itself
+func (f *Framework) binderForPod(pod *v1.Pod) *extender.HTTPExtender {
+	for _, ext := range f.extenders {
+		if ext.IsBinder() && ext.IsInterested(pod) {
+			return ext
+		}
+	}
+	return nil
+}
+
+// HasScorePlugins reports whether the profile ranks nodes at all
+func (f *Framework) HasScorePlugins() bool {
+	return len(f.scorePlugins) > 0 || len(f.extenders) > 0
+}
+
+// DefaultNormalizeScore scales scores so the highest one becomes maxPriority.
+// With reverse set, low raw scores end up with high normalized scores.
+func DefaultNormalizeScore(maxPriority int64, reverse bool, scores NodeScoreList) {
+	var maxCount int64
+	for i := range scores {
+		if scores[i].Score > maxCount {
+			maxCount = scores[i].Score
+		}
+	}
+	if maxCount == 0 {
+		if reverse {
+			for i := range scores {
+				scores[i].Score = maxPriority
+			}
+		}
+		return
+	}
+	for i := range scores {
+		score := scores[i].Score * maxPriority / maxCount
+		if reverse {
+			score = maxPriority - score
+		}
+		scores[i].Score = score
+	}
+}
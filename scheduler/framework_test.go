@@ -0,0 +1,1033 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sample-k8-scheduler/scheduler/extender"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// licenseFilter only allows nodes labeled with a license
+type licenseFilter struct{}
+
+func (p *licenseFilter) Name() string { return "LicenseFilter" }
+
+func (p *licenseFilter) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	if nodeInfo.Node.Labels["license"] != "yes" {
+		return fmt.Errorf("node(s) had no license")
+	}
+	return nil
+}
+
+// rackScore scores nodes by the numeric value of their rack label
+type rackScore struct{}
+
+func (p *rackScore) Name() string { return "RackScore" }
+
+func (p *rackScore) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, error) {
+	return strconv.ParseInt(nodeInfo.Node.Labels["rack"], 10, 64)
+}
+
+func (p *rackScore) NormalizeScore(state *CycleState, pod *v1.Pod, scores NodeScoreList) error {
+	DefaultNormalizeScore(MaxNodeScore, false, scores)
+	return nil
+}
+
+// Helper for test: a ready node with the given labels
+func readyNode(name string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+// Helper for test: enable a profile for the duration of the test
+func useProfile(t *testing.T, profile Profile) {
+	t.Helper()
+	if err := AddProfile(profile); err != nil {
+		t.Fatalf("Failed to add profile: %v", err)
+	}
+	t.Cleanup(func() { delete(profiles, profile.SchedulerName) })
+}
+
+func TestPendingPodsOfEveryProfile(t *testing.T) {
+	resetQueues()
+	batch := DefaultProfile
+	batch.SchedulerName = "batch-scheduler"
+	useProfile(t, batch)
+
+	node := readyNode("node-a", nil)
+	node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("4"), v1.ResourcePods: resourceMustParse("10")}
+	pod := func(name, schedulerName, nodeName string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1.PodSpec{SchedulerName: schedulerName, NodeName: nodeName},
+		}
+	}
+	clientset := fake.NewSimpleClientset(node,
+		pod("batch-job", "batch-scheduler", ""),
+		pod("web", SchedulerName, ""),
+		pod("other", "default-scheduler", ""),
+		pod("running", "batch-scheduler", "node-a"),
+	)
+
+	pending, err := listPendingPods(clientset)
+	if err != nil {
+		t.Fatalf("Failed to list pending pods: %v", err)
+	}
+	names := []string{}
+	for _, p := range pending {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, ",") != "batch-job,web" {
+		t.Fatalf("Expected the unassigned pods of both profiles, got %v", names)
+	}
+
+	// Pods of the second profile are scheduled like those of the default one
+	ScheduleCycle(clientset, &rest.Config{Host: "http://127.0.0.1:1"}, pending)
+	bound := map[string]bool{}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "create" && action.GetSubresource() == "binding" {
+			bound[action.(k8stesting.CreateAction).GetObject().(*v1.Binding).Name] = true
+		}
+	}
+	if !bound["batch-job"] || !bound["web"] {
+		t.Errorf("Expected batch-job and web to be bound, got %v", bound)
+	}
+}
+
+func TestFrameworkCustomPlugins(t *testing.T) {
+	registry["LicenseFilter"] = func() (Plugin, error) { return &licenseFilter{}, nil }
+	registry["RackScore"] = func() (Plugin, error) { return &rackScore{}, nil }
+	t.Cleanup(func() {
+		delete(registry, "LicenseFilter")
+		delete(registry, "RackScore")
+	})
+
+	useProfile(t, Profile{
+		SchedulerName: "licensed",
+		Filter:        append(append([]PluginConfig{}, DefaultProfile.Filter...), PluginConfig{Name: "LicenseFilter"}),
+		Score:         []PluginConfig{{Name: "RackScore", Weight: 2}},
+	})
+
+	clientset := fake.NewSimpleClientset(
+		readyNode("unlicensed", map[string]string{"rack": "9"}),
+		readyNode("rack-1", map[string]string{"license": "yes", "rack": "1"}),
+		readyNode("rack-5", map[string]string{"license": "yes", "rack": "5"}),
+	)
+
+	pod := &v1.Pod{Spec: v1.PodSpec{SchedulerName: "licensed"}}
+	node, err := SelectBestNode(clientset, pod)
+	if err != nil {
+		t.Fatalf("Expected a licensed node, got error: %v", err)
+	}
+	if node != "rack-5" {
+		t.Errorf("Expected rack-5 to win on score, got %s", node)
+	}
+
+	// Pods of other profiles are not affected by the license filter
+	defaultPod := &v1.Pod{Spec: v1.PodSpec{NodeSelector: map[string]string{"rack": "9"}}}
+	node, err = SelectBestNode(clientset, defaultPod)
+	if err != nil || node != "unlicensed" {
+		t.Errorf("Expected default profile to pick unlicensed, got %q (err: %v)", node, err)
+	}
+}
+
+func TestRegisterPluginDuplicate(t *testing.T) {
+	if err := RegisterPlugin(NodeConditionsName, func() (Plugin, error) { return &NodeConditions{}, nil }); err == nil {
+		t.Error("Expected error when registering a plugin name twice")
+	}
+}
+
+func TestNewFrameworkValidation(t *testing.T) {
+	if _, err := NewFramework(registry, Profile{Filter: []PluginConfig{{Name: "Missing"}}}); err == nil {
+		t.Error("Expected error for unregistered plugin")
+	}
+	// NodeConditions has no Score method
+	if _, err := NewFramework(registry, Profile{Score: []PluginConfig{{Name: NodeConditionsName}}}); err == nil {
+		t.Error("Expected error when enabling a filter-only plugin as score plugin")
+	}
+}
+
+func TestDefaultNormalizeScore(t *testing.T) {
+	scores := NodeScoreList{{Name: "a", Score: 10}, {Name: "b", Score: 5}, {Name: "c", Score: 0}}
+	DefaultNormalizeScore(MaxNodeScore, false, scores)
+	if scores[0].Score != 100 || scores[1].Score != 50 || scores[2].Score != 0 {
+		t.Errorf("Unexpected normalized scores: %v", scores)
+	}
+
+	scores = NodeScoreList{{Name: "a", Score: 10}, {Name: "b", Score: 5}}
+	DefaultNormalizeScore(MaxNodeScore, true, scores)
+	if scores[0].Score != 0 || scores[1].Score != 50 {
+		t.Errorf("Unexpected reversed scores: %v", scores)
+	}
+}
+
+func TestFitErrorMessage(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "not-ready"}},
+		readyNode("small", nil),
+	)
+	pod := podWithRequests(v1.ResourceList{v1.ResourceCPU: resourceMustParse("1")})
+	_, err := SelectBestNode(clientset, pod)
+	fitErr, ok := err.(*FitError)
+	if !ok {
+		t.Fatalf("Expected a FitError, got %v", err)
+	}
+	if len(fitErr.NodeReasons) != 2 {
+		t.Errorf("Expected a reason for both nodes, got %v", fitErr.NodeReasons)
+	}
+	msg := fitErr.Error()
+	if !strings.HasPrefix(msg, "0/2 nodes are available") ||
+		!strings.Contains(msg, "1 insufficient cpu") ||
+		!strings.Contains(msg, "1 node(s) were not ready") {
+		t.Errorf("Unexpected fit error message: %s", msg)
+	}
+}
+
+// Helper for test: a pod bound to nodeName with the given labels
+func boundPodWithLabels(name, namespace, nodeName string, podLabels map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: podLabels},
+		Spec:       v1.PodSpec{NodeName: nodeName},
+	}
+}
+
+func TestInterPodAffinity(t *testing.T) {
+	hostname := "kubernetes.io/hostname"
+	zone := "topology.kubernetes.io/zone"
+	webSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	dbSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+
+	nodes := []*v1.Node{
+		readyNode("node-a", map[string]string{hostname: "node-a", zone: "z1"}),
+		readyNode("node-b", map[string]string{hostname: "node-b", zone: "z2"}),
+	}
+	newClientset := func(pods ...*v1.Pod) *fake.Clientset {
+		clientset := fake.NewSimpleClientset(
+			nodes[0], nodes[1],
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-x", Labels: map[string]string{"team": "x"}}},
+		)
+		for _, p := range pods {
+			clientset.Tracker().Add(p)
+		}
+		return clientset
+	}
+	newPod := func(podLabels map[string]string, affinity *v1.Affinity) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "new", Namespace: "default", Labels: podLabels},
+			Spec:       v1.PodSpec{Affinity: affinity},
+		}
+	}
+
+	// Required anti-affinity keeps replicas off a shared host
+	antiAffinity := &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+			LabelSelector: webSelector,
+			TopologyKey:   hostname,
+		}},
+	}}
+	clientset := newClientset(boundPodWithLabels("web-1", "default", "node-a", map[string]string{"app": "web"}))
+	node, err := SelectBestNode(clientset, newPod(map[string]string{"app": "web"}, antiAffinity))
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected anti-affinity to pick node-b, got %q (err: %v)", node, err)
+	}
+
+	// Anti-affinity of an existing pod also repels the incoming pod
+	existing := boundPodWithLabels("web-1", "default", "node-a", map[string]string{"app": "web"})
+	existing.Spec.Affinity = antiAffinity
+	clientset = newClientset(existing)
+	node, err = SelectBestNode(clientset, newPod(map[string]string{"app": "web"}, nil))
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected existing pod anti-affinity to pick node-b, got %q (err: %v)", node, err)
+	}
+
+	// Required affinity follows the db pod into its zone
+	affinity := &v1.Affinity{PodAffinity: &v1.PodAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+			LabelSelector: dbSelector,
+			TopologyKey:   zone,
+		}},
+	}}
+	clientset = newClientset(boundPodWithLabels("db-1", "default", "node-b", map[string]string{"app": "db"}))
+	node, err = SelectBestNode(clientset, newPod(nil, affinity))
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected affinity to pick node-b, got %q (err: %v)", node, err)
+	}
+
+	// Without any db pod the affinity cannot be satisfied...
+	clientset = newClientset()
+	if _, err := SelectBestNode(clientset, newPod(nil, affinity)); err == nil {
+		t.Error("Expected error when no pod matches the required affinity")
+	}
+	// ...unless the pod is the first of its own group
+	if _, err := SelectBestNode(clientset, newPod(map[string]string{"app": "db"}, affinity)); err != nil {
+		t.Errorf("Expected first pod matching its own affinity to be scheduled, got %v", err)
+	}
+
+	// Namespace selector limits the term to labeled namespaces
+	nsAffinity := &v1.Affinity{PodAffinity: &v1.PodAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+			LabelSelector:     dbSelector,
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "x"}},
+			TopologyKey:       zone,
+		}},
+	}}
+	clientset = newClientset(
+		boundPodWithLabels("db-default", "default", "node-a", map[string]string{"app": "db"}),
+		boundPodWithLabels("db-team-x", "team-x", "node-b", map[string]string{"app": "db"}),
+	)
+	node, err = SelectBestNode(clientset, newPod(nil, nsAffinity))
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected namespace selector to pick node-b, got %q (err: %v)", node, err)
+	}
+
+	// Preferred anti-affinity only ranks nodes
+	preferredAnti := &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{{
+			Weight: 100,
+			PodAffinityTerm: v1.PodAffinityTerm{
+				LabelSelector: webSelector,
+				TopologyKey:   hostname,
+			},
+		}},
+	}}
+	clientset = newClientset(boundPodWithLabels("web-1", "default", "node-a", map[string]string{"app": "web"}))
+	node, err = SelectBestNode(clientset, newPod(map[string]string{"app": "web"}, preferredAnti))
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected preferred anti-affinity to pick node-b, got %q (err: %v)", node, err)
+	}
+
+	// Namespaces are only listed for namespaceSelector terms
+	clientset = newClientset(boundPodWithLabels("db-1", "default", "node-b", map[string]string{"app": "db"}))
+	clientset.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("namespaces unavailable")
+	})
+	if _, err := SelectBestNode(clientset, newPod(nil, nil)); err != nil {
+		t.Errorf("Expected pod without affinity to skip listing namespaces, got %v", err)
+	}
+	node, err = SelectBestNode(clientset, newPod(nil, affinity))
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected affinity without namespace selector to skip listing namespaces, got %q (err: %v)", node, err)
+	}
+	if _, err := SelectBestNode(clientset, newPod(nil, nsAffinity)); err == nil {
+		t.Error("Expected namespace selector to need the namespace list")
+	}
+}
+
+func TestPodTopologySpread(t *testing.T) {
+	zone := "topology.kubernetes.io/zone"
+	webLabels := map[string]string{"app": "web"}
+	webSelector := &metav1.LabelSelector{MatchLabels: webLabels}
+
+	tainted := readyNode("node-c", map[string]string{zone: "z3", "pool": "c"})
+	tainted.Spec.Taints = []v1.Taint{{Key: "dedicated", Effect: v1.TaintEffectNoSchedule}}
+	newClientset := func(withThirdZone bool, pods ...*v1.Pod) *fake.Clientset {
+		clientset := fake.NewSimpleClientset(
+			readyNode("node-a", map[string]string{zone: "z1", "pool": "ab"}),
+			readyNode("node-b", map[string]string{zone: "z2", "pool": "ab"}),
+		)
+		if withThirdZone {
+			clientset.Tracker().Add(tainted)
+		}
+		for _, p := range pods {
+			clientset.Tracker().Add(p)
+		}
+		return clientset
+	}
+	newPod := func(c v1.TopologySpreadConstraint) *v1.Pod {
+		c.TopologyKey = zone
+		c.LabelSelector = webSelector
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "new", Namespace: "default", Labels: webLabels},
+			Spec: v1.PodSpec{
+				TopologySpreadConstraints: []v1.TopologySpreadConstraint{c},
+				// Tolerating the taint keeps node-c feasible for the TaintToleration filter
+				Tolerations: []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpExists}},
+			},
+		}
+	}
+	int32Ptr := func(i int32) *int32 { return &i }
+	policyPtr := func(p v1.NodeInclusionPolicy) *v1.NodeInclusionPolicy { return &p }
+
+	// maxSkew 1 sends the pod to the empty zone
+	clientset := newClientset(false, boundPodWithLabels("web-1", "default", "node-a", webLabels))
+	node, err := SelectBestNode(clientset, newPod(v1.TopologySpreadConstraint{MaxSkew: 1, WhenUnsatisfiable: v1.DoNotSchedule}))
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected maxSkew to pick node-b, got %q (err: %v)", node, err)
+	}
+
+	// With fewer domains than minDomains the global minimum is 0
+	clientset = newClientset(false,
+		boundPodWithLabels("web-1", "default", "node-a", webLabels),
+		boundPodWithLabels("web-2", "default", "node-b", webLabels),
+	)
+	if _, err := SelectBestNode(clientset, newPod(v1.TopologySpreadConstraint{MaxSkew: 1, WhenUnsatisfiable: v1.DoNotSchedule})); err != nil {
+		t.Errorf("Expected pod to fit with even spread, got %v", err)
+	}
+	if _, err := SelectBestNode(clientset, newPod(v1.TopologySpreadConstraint{MaxSkew: 1, MinDomains: int32Ptr(3), WhenUnsatisfiable: v1.DoNotSchedule})); err == nil {
+		t.Error("Expected minDomains 3 to block scheduling with only 2 zones")
+	}
+
+	// nodeAffinityPolicy Honor leaves node-c's empty zone out of the skew for a pod pinned to pool ab
+	clientset = newClientset(true,
+		boundPodWithLabels("web-1", "default", "node-a", webLabels),
+		boundPodWithLabels("web-2", "default", "node-b", webLabels),
+	)
+	pinned := newPod(v1.TopologySpreadConstraint{MaxSkew: 1, WhenUnsatisfiable: v1.DoNotSchedule})
+	pinned.Spec.NodeSelector = map[string]string{"pool": "ab"}
+	if _, err := SelectBestNode(clientset, pinned); err != nil {
+		t.Errorf("Expected nodeAffinityPolicy Honor to ignore zone z3, got %v", err)
+	}
+	pinned = newPod(v1.TopologySpreadConstraint{MaxSkew: 1, WhenUnsatisfiable: v1.DoNotSchedule, NodeAffinityPolicy: policyPtr(v1.NodeInclusionPolicyIgnore)})
+	pinned.Spec.NodeSelector = map[string]string{"pool": "ab"}
+	if _, err := SelectBestNode(clientset, pinned); err == nil {
+		t.Error("Expected nodeAffinityPolicy Ignore to count empty zone z3 and block scheduling")
+	}
+
+	// nodeTaintsPolicy Honor leaves the tainted zone out for pods that don't tolerate it
+	intolerant := newPod(v1.TopologySpreadConstraint{MaxSkew: 1, WhenUnsatisfiable: v1.DoNotSchedule, NodeTaintsPolicy: policyPtr(v1.NodeInclusionPolicyHonor)})
+	intolerant.Spec.Tolerations = nil
+	if _, err := SelectBestNode(clientset, intolerant); err != nil {
+		t.Errorf("Expected nodeTaintsPolicy Honor to ignore tainted zone z3, got %v", err)
+	}
+	intolerant = newPod(v1.TopologySpreadConstraint{MaxSkew: 1, WhenUnsatisfiable: v1.DoNotSchedule})
+	intolerant.Spec.Tolerations = nil
+	if _, err := SelectBestNode(clientset, intolerant); err == nil {
+		t.Error("Expected default nodeTaintsPolicy Ignore to count tainted zone z3 and block scheduling")
+	}
+
+	// ScheduleAnyway only prefers the emptier zone
+	clientset = newClientset(false,
+		boundPodWithLabels("web-1", "default", "node-a", webLabels),
+		boundPodWithLabels("web-2", "default", "node-a", webLabels),
+	)
+	node, err = SelectBestNode(clientset, newPod(v1.TopologySpreadConstraint{MaxSkew: 1, WhenUnsatisfiable: v1.ScheduleAnyway}))
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected ScheduleAnyway to prefer node-b, got %q (err: %v)", node, err)
+	}
+}
+
+func TestNodeConditionsAndUnschedulable(t *testing.T) {
+	withCondition := func(name string, condType v1.NodeConditionType, status v1.ConditionStatus) *v1.Node {
+		n := readyNode(name, nil)
+		if condType == v1.NodeReady {
+			n.Status.Conditions[0].Status = status
+		} else {
+			n.Status.Conditions = append(n.Status.Conditions, v1.NodeCondition{Type: condType, Status: status})
+		}
+		return n
+	}
+	cordoned := readyNode("cordoned", nil)
+	cordoned.Spec.Unschedulable = true
+
+	tests := []struct {
+		node        *v1.Node
+		tolerations []v1.Toleration
+		wantReason  string
+	}{
+		{withCondition("not-ready", v1.NodeReady, v1.ConditionFalse), nil, "node(s) were not ready"},
+		{withCondition("unknown", v1.NodeReady, v1.ConditionUnknown), nil, "node(s) were unreachable"},
+		{withCondition("memory", v1.NodeMemoryPressure, v1.ConditionTrue), nil, "node(s) had memory pressure"},
+		{withCondition("disk", v1.NodeDiskPressure, v1.ConditionTrue), nil, "node(s) had disk pressure"},
+		{withCondition("pid", v1.NodePIDPressure, v1.ConditionTrue), nil, "node(s) had pid pressure"},
+		{withCondition("network", v1.NodeNetworkUnavailable, v1.ConditionTrue), nil, "node(s) had unavailable network"},
+		{withCondition("no-pressure", v1.NodeMemoryPressure, v1.ConditionFalse), nil, ""},
+		{cordoned, nil, "node(s) were unschedulable"},
+		// Tolerating the matching node.kubernetes.io/* taint lets the pod through
+		{withCondition("disk-tolerated", v1.NodeDiskPressure, v1.ConditionTrue),
+			[]v1.Toleration{{Key: TaintNodeDiskPressure, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}}, ""},
+		{cordoned, []v1.Toleration{{Key: TaintNodeUnschedulable, Operator: v1.TolerationOpExists}}, ""},
+	}
+	for _, tt := range tests {
+		pod := &v1.Pod{Spec: v1.PodSpec{Tolerations: tt.tolerations}}
+		_, err := SelectBestNode(fake.NewSimpleClientset(tt.node), pod)
+		if tt.wantReason == "" {
+			if err != nil {
+				t.Errorf("%s: expected node to be feasible, got %v", tt.node.Name, err)
+			}
+			continue
+		}
+		fitErr, ok := err.(*FitError)
+		if !ok {
+			t.Errorf("%s: expected a FitError, got %v", tt.node.Name, err)
+			continue
+		}
+		if got := fitErr.NodeReasons[tt.node.Name]; got != tt.wantReason {
+			t.Errorf("%s: expected reason %q, got %q", tt.node.Name, tt.wantReason, got)
+		}
+	}
+}
+
+func TestRecordSchedulingFailure(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default"}}
+	clientset := fake.NewSimpleClientset(pod)
+	schedErr := &FitError{NumAllNodes: 1, NodeReasons: map[string]string{"node-a": "node(s) were unschedulable"}}
+
+	recordSchedulingFailure(clientset, pod, schedErr)
+
+	updated, err := clientset.CoreV1().Pods("default").Get(context.TODO(), "pending", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get pod: %v", err)
+	}
+	var found *v1.PodCondition
+	for i := range updated.Status.Conditions {
+		if updated.Status.Conditions[i].Type == v1.PodScheduled {
+			found = &updated.Status.Conditions[i]
+		}
+	}
+	if found == nil || found.Status != v1.ConditionFalse || found.Reason != v1.PodReasonUnschedulable {
+		t.Fatalf("Expected PodScheduled=False/Unschedulable condition, got %+v", updated.Status.Conditions)
+	}
+	if found.Message != "0/1 nodes are available: 1 node(s) were unschedulable" {
+		t.Errorf("Unexpected condition message: %s", found.Message)
+	}
+
+	events, _ := clientset.CoreV1().Events("default").List(context.TODO(), metav1.ListOptions{})
+	if len(events.Items) != 1 || events.Items[0].Reason != "FailedScheduling" {
+		t.Errorf("Expected one FailedScheduling event, got %+v", events.Items)
+	}
+
+	// Recording the same failure again is a no-op
+	recordSchedulingFailure(clientset, updated, schedErr)
+	events, _ = clientset.CoreV1().Events("default").List(context.TODO(), metav1.ListOptions{})
+	if len(events.Items) != 1 {
+		t.Errorf("Expected no new event for an unchanged failure, got %d events", len(events.Items))
+	}
+}
+
+func TestNodePortsAndMaxPods(t *testing.T) {
+	podWithHostPort := func(name, nodeName, hostIP string, port int32, protocol v1.Protocol) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1.PodSpec{
+				NodeName: nodeName,
+				Containers: []v1.Container{{
+					Ports: []v1.ContainerPort{{ContainerPort: 8080, HostPort: port, HostIP: hostIP, Protocol: protocol}},
+				}},
+			},
+		}
+	}
+
+	clientset := fake.NewSimpleClientset(
+		readyNode("node-a", nil),
+		readyNode("node-b", nil),
+		podWithHostPort("ingress-a", "node-a", "", 80, ""),
+	)
+	node, err := SelectBestNode(clientset, podWithHostPort("ingress-new", "", "", 80, v1.ProtocolTCP))
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected host port conflict to pick node-b, got %q (err: %v)", node, err)
+	}
+	// Same port over UDP does not conflict
+	node, err = SelectBestNode(clientset, podWithHostPort("dns", "", "", 80, v1.ProtocolUDP))
+	if err != nil || node != "node-a" {
+		t.Errorf("Expected UDP port to fit node-a, got %q (err: %v)", node, err)
+	}
+
+	// A sidecar init container holds its host port, a regular init container doesn't
+	always := v1.ContainerRestartPolicyAlways
+	sidecar := podWithHostPort("proxy-a", "node-a", "", 9090, "")
+	sidecar.Spec.InitContainers = sidecar.Spec.Containers
+	sidecar.Spec.InitContainers[0].RestartPolicy = &always
+	sidecar.Spec.Containers = []v1.Container{{}}
+	setup := podWithHostPort("setup-b", "node-b", "", 9091, "")
+	setup.Spec.InitContainers = setup.Spec.Containers
+	setup.Spec.Containers = []v1.Container{{}}
+	clientset = fake.NewSimpleClientset(readyNode("node-a", nil), readyNode("node-b", nil), sidecar, setup)
+	node, err = SelectBestNode(clientset, podWithHostPort("proxy-new", "", "", 9090, ""))
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected sidecar host port conflict to pick node-b, got %q (err: %v)", node, err)
+	}
+	if ports := getHostPorts(setup); len(ports) != 0 {
+		t.Errorf("Expected regular init container ports to be ignored, got %v", ports)
+	}
+	sidecarPod := podWithHostPort("proxy-new", "", "", 9090, "")
+	sidecarPod.Spec.InitContainers = sidecarPod.Spec.Containers
+	sidecarPod.Spec.InitContainers[0].RestartPolicy = &always
+	sidecarPod.Spec.Containers = []v1.Container{{}}
+	node, err = SelectBestNode(clientset, sidecarPod)
+	if err != nil || node != "node-b" {
+		t.Errorf("Expected incoming sidecar host port to pick node-b, got %q (err: %v)", node, err)
+	}
+
+	if hostPortsConflict(
+		v1.ContainerPort{HostPort: 80, HostIP: "10.0.0.1"},
+		v1.ContainerPort{HostPort: 80, HostIP: "10.0.0.2"},
+	) {
+		t.Error("Expected different host IPs not to conflict")
+	}
+	if !hostPortsConflict(
+		v1.ContainerPort{HostPort: 80, HostIP: "10.0.0.1"},
+		v1.ContainerPort{HostPort: 80, HostIP: "0.0.0.0"},
+	) {
+		t.Error("Expected wildcard host IP to conflict with every address")
+	}
+
+	full := readyNode("full", nil)
+	full.Status.Allocatable = v1.ResourceList{v1.ResourcePods: resourceMustParse("1")}
+	clientset = fake.NewSimpleClientset(full, boundPodWithLabels("existing", "default", "full", nil))
+	_, err = SelectBestNode(clientset, &v1.Pod{})
+	if fitErr, ok := err.(*FitError); !ok || fitErr.NodeReasons["full"] != "too many pods" {
+		t.Errorf("Expected too many pods on full node, got %v", err)
+	}
+}
+
+func TestVolumeBinding(t *testing.T) {
+	zone := "topology.kubernetes.io/zone"
+	wffc := storagev1.VolumeBindingWaitForFirstConsumer
+	immediate := storagev1.VolumeBindingImmediate
+	zonalClass := "zonal"
+	immediateClass := "immediate"
+	localClass := "local"
+
+	claim := func(name, class, volumeName string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &class,
+				VolumeName:       volumeName,
+				AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.VolumeResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceStorage: resourceMustParse("10Gi"),
+				}},
+			},
+		}
+	}
+	zoneAffinity := func(zoneValue string) *v1.VolumeNodeAffinity {
+		return &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+			MatchExpressions: []v1.NodeSelectorRequirement{{Key: zone, Operator: v1.NodeSelectorOpIn, Values: []string{zoneValue}}},
+		}}}}
+	}
+	podWithClaims := func(claims ...string) *v1.Pod {
+		p := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "stateful", Namespace: "default"}}
+		for _, c := range claims {
+			p.Spec.Volumes = append(p.Spec.Volumes, v1.Volume{
+				Name:         c,
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: c}},
+			})
+		}
+		return p
+	}
+
+	clientset := fake.NewSimpleClientset(
+		readyNode("node-a", map[string]string{zone: "z1"}),
+		readyNode("node-b", map[string]string{zone: "z2"}),
+		&storagev1.StorageClass{
+			ObjectMeta:        metav1.ObjectMeta{Name: zonalClass},
+			Provisioner:       "ebs.csi.aws.com",
+			VolumeBindingMode: &wffc,
+			AllowedTopologies: []v1.TopologySelectorTerm{{
+				MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{{Key: zone, Values: []string{"z2"}}},
+			}},
+		},
+		&storagev1.StorageClass{
+			ObjectMeta:        metav1.ObjectMeta{Name: immediateClass},
+			Provisioner:       "ebs.csi.aws.com",
+			VolumeBindingMode: &immediate,
+		},
+		&storagev1.StorageClass{
+			ObjectMeta:        metav1.ObjectMeta{Name: localClass},
+			Provisioner:       noProvisioner,
+			VolumeBindingMode: &wffc,
+		},
+		claim("bound", zonalClass, "pv-z2"),
+		claim("delayed", zonalClass, ""),
+		claim("pending-immediate", immediateClass, ""),
+		claim("local", localClass, ""),
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-z2"},
+			Spec:       v1.PersistentVolumeSpec{NodeAffinity: zoneAffinity("z2")},
+		},
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "local-pv-a"},
+			Spec: v1.PersistentVolumeSpec{
+				StorageClassName: localClass,
+				NodeAffinity:     zoneAffinity("z1"),
+				AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Capacity:         v1.ResourceList{v1.ResourceStorage: resourceMustParse("20Gi")},
+			},
+			Status: v1.PersistentVolumeStatus{Phase: v1.VolumeAvailable},
+		},
+	)
+
+	tests := []struct {
+		claim   string
+		want    string
+		wantErr bool
+	}{
+		{claim: "bound", want: "node-b"},
+		{claim: "delayed", want: "node-b"},
+		{claim: "local", want: "node-a"},
+		{claim: "pending-immediate", wantErr: true},
+		{claim: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		node, err := SelectBestNode(clientset, podWithClaims(tt.claim))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got node %s", tt.claim, node)
+			}
+			continue
+		}
+		if err != nil || node != tt.want {
+			t.Errorf("%s: expected %s, got %q (err: %v)", tt.claim, tt.want, node, err)
+		}
+	}
+
+	// Two local claims can't share the only local PV of node-a
+	clientset.Tracker().Add(claim("local-2", localClass, ""))
+	twoLocal := podWithClaims("local", "local-2")
+	if node, err := SelectBestNode(clientset, twoLocal); err == nil {
+		t.Errorf("Expected no node for two claims and one local PV, got %s", node)
+	}
+	clientset.Tracker().Add(&v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "local-pv-a2"},
+		Spec: v1.PersistentVolumeSpec{
+			StorageClassName: localClass,
+			NodeAffinity:     zoneAffinity("z1"),
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Capacity:         v1.ResourceList{v1.ResourceStorage: resourceMustParse("10Gi")},
+		},
+		Status: v1.PersistentVolumeStatus{Phase: v1.VolumeAvailable},
+	})
+	node, err := SelectBestNode(clientset, twoLocal)
+	if err != nil || node != "node-a" {
+		t.Fatalf("Expected node-a for two local claims, got %q (err: %v)", node, err)
+	}
+
+	// Binding the pod binds each local claim to its own PV first
+	clientset.Tracker().Add(twoLocal)
+	if err := BindPod(clientset, twoLocal, node); err != nil {
+		t.Fatalf("Failed to bind pod: %v", err)
+	}
+	claimed := map[string]string{}
+	for _, name := range []string{"local-pv-a", "local-pv-a2"} {
+		pv, _ := clientset.CoreV1().PersistentVolumes().Get(context.TODO(), name, metav1.GetOptions{})
+		if pv.Spec.ClaimRef == nil || pv.Annotations[boundByControllerAnnotation] != "yes" {
+			t.Errorf("Expected %s to be bound to a claim, got %+v", name, pv.Spec.ClaimRef)
+			continue
+		}
+		claimed[pv.Spec.ClaimRef.Name] = name
+	}
+	if claimed["local"] == "" || claimed["local-2"] == "" {
+		t.Errorf("Expected local and local-2 bound to different PVs, got %v", claimed)
+	}
+
+	// A claim to provision is told which node the pod runs on
+	delayed := podWithClaims("delayed")
+	delayed.Name = "provisioned"
+	clientset.Tracker().Add(delayed)
+	if err := BindPod(clientset, delayed, "node-b"); err != nil {
+		t.Fatalf("Failed to bind pod: %v", err)
+	}
+	pvc, _ := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.TODO(), "delayed", metav1.GetOptions{})
+	if pvc.Annotations[selectedNodeAnnotation] != "node-b" {
+		t.Errorf("Expected delayed claim to select node-b, got %v", pvc.Annotations)
+	}
+}
+
+func TestNodeVolumeLimits(t *testing.T) {
+	driver := "ebs.csi.aws.com"
+	class := "csi"
+	wffc := storagev1.VolumeBindingWaitForFirstConsumer
+	limit := int32(1)
+
+	claimWithPV := func(name string) (*v1.PersistentVolumeClaim, *v1.PersistentVolume) {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &class, VolumeName: "pv-" + name},
+		}, &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-" + name},
+			Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: "vol-" + name},
+			}},
+		}
+	}
+	existingClaim, existingPV := claimWithPV("existing")
+	newClaim, newPV := claimWithPV("new")
+	podUsing := func(name, nodeName, claimName string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1.PodSpec{
+				NodeName: nodeName,
+				Volumes: []v1.Volume{{
+					Name:         "data",
+					VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName}},
+				}},
+			},
+		}
+	}
+
+	limitedNode := readyNode("node-a", nil)
+	legacyNode := readyNode("node-b", nil)
+	legacyNode.Status.Allocatable = v1.ResourceList{
+		v1.ResourceName(attachableVolumesCSIPrefix + driver): resourceMustParse("1"),
+	}
+	clientset := fake.NewSimpleClientset(
+		limitedNode, legacyNode,
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: class}, Provisioner: driver, VolumeBindingMode: &wffc},
+		&storagev1.CSINode{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+			Spec: storagev1.CSINodeSpec{Drivers: []storagev1.CSINodeDriver{{
+				Name: driver, NodeID: "node-a", Allocatable: &storagev1.VolumeNodeResources{Count: &limit},
+			}}},
+		},
+		existingClaim, existingPV, newClaim, newPV,
+		podUsing("on-a", "node-a", "existing"),
+		podUsing("on-b", "node-b", "existing"),
+	)
+
+	// Both nodes already attach one volume of the driver
+	if _, err := SelectBestNode(clientset, podUsing("new", "", "new")); err == nil {
+		t.Error("Expected attach limits to rule out both nodes")
+	}
+	// Sharing the already attached volume does not need another attachment
+	if _, err := SelectBestNode(clientset, podUsing("shared", "", "existing")); err != nil {
+		t.Errorf("Expected pod sharing an attached volume to fit, got %v", err)
+	}
+}
+
+func TestImageLocality(t *testing.T) {
+	withImages := func(name string, images ...v1.ContainerImage) *v1.Node {
+		n := readyNode(name, nil)
+		n.Status.Images = images
+		return n
+	}
+	bigImage := v1.ContainerImage{Names: []string{"registry.example.com/ml/trainer:v1"}, SizeBytes: 3000 * mb}
+	smallImage := v1.ContainerImage{Names: []string{"registry.example.com/tools/sidecar:latest"}, SizeBytes: 10 * mb}
+	clientset := fake.NewSimpleClientset(
+		withImages("cold"),
+		withImages("small-only", smallImage),
+		withImages("warm", bigImage),
+	)
+	pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{
+		{Image: "registry.example.com/ml/trainer:v1"},
+		{Image: "registry.example.com/tools/sidecar"},
+	}}}
+	node, err := SelectBestNode(clientset, pod)
+	if err != nil || node != "warm" {
+		t.Errorf("Expected warm node with the large image, got %q (err: %v)", node, err)
+	}
+
+	if got := normalizedImageName("nginx"); got != "nginx:latest" {
+		t.Errorf("Expected nginx:latest, got %s", got)
+	}
+	if got := normalizedImageName("registry:5000/nginx"); got != "registry:5000/nginx:latest" {
+		t.Errorf("Expected registry:5000/nginx:latest, got %s", got)
+	}
+	if got := normalizedImageName("nginx:1.25"); got != "nginx:1.25" {
+		t.Errorf("Expected nginx:1.25, got %s", got)
+	}
+
+	// Images on every node are scaled by their spread like upstream
+	is := &imageState{size: 1000 * mb, numNodes: 1}
+	if got := scaledImageScore(is, 4); got != 250*mb {
+		t.Errorf("Expected image score scaled to a quarter, got %d", got)
+	}
+	if got := calculateImageLocalityPriority(10*mb, 1); got != 0 {
+		t.Errorf("Expected images below the min threshold to score 0, got %d", got)
+	}
+	if got := calculateImageLocalityPriority(5000*mb, 1); got != MaxNodeScore {
+		t.Errorf("Expected images above the max threshold to score %d, got %d", MaxNodeScore, got)
+	}
+}
+
+func TestLoadExtenders(t *testing.T) {
+	saved := DefaultProfile
+	t.Cleanup(func() {
+		DefaultProfile = saved
+		delete(profiles, SchedulerName)
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var args extender.ExtenderArgs
+		json.NewDecoder(r.Body).Decode(&args)
+		result := extender.ExtenderFilterResult{Nodes: &v1.NodeList{}, FailedNodes: extender.FailedNodesMap{}}
+		for _, node := range args.Nodes.Items {
+			if node.Labels["licensed"] == "true" {
+				result.Nodes.Items = append(result.Nodes.Items, node)
+			} else {
+				result.FailedNodes[node.Name] = "node(s) had no license seat"
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+	writeFile := func(content string) string {
+		path := filepath.Join(t.TempDir(), "extenders.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write extenders file: %v", err)
+		}
+		return path
+	}
+
+	path := writeFile(`extenders:
+- urlPrefix: ` + server.URL + `/scheduler
+  filterVerb: filter
+  httpTimeout: 2s
+  managedResources:
+  - name: cpu
+`)
+	if err := LoadExtenders(path); err != nil {
+		t.Fatalf("Failed to load extenders: %v", err)
+	}
+	loaded := DefaultProfile.Extenders
+	if len(loaded) != 1 || loaded[0].HTTPTimeout != 2*time.Second || len(loaded[0].ManagedResources) != 1 {
+		t.Fatalf("Unexpected extenders loaded: %+v", loaded)
+	}
+
+	// Pods of the default profile now go through the extender
+	unlicensed := readyNode("unlicensed", nil)
+	licensed := readyNode("licensed", map[string]string{"licensed": "true"})
+	for _, n := range []*v1.Node{unlicensed, licensed} {
+		n.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("4"), v1.ResourcePods: resourceMustParse("10")}
+	}
+	clientset := fake.NewSimpleClientset(unlicensed, licensed)
+	node, err := SelectBestNode(clientset, podWithRequests(v1.ResourceList{v1.ResourceCPU: resourceMustParse("1")}))
+	if err != nil || node != "licensed" {
+		t.Errorf("Expected the loaded extender to pick licensed, got %q (err: %v)", node, err)
+	}
+
+	// An invalid extender is reported and the default profile is kept
+	path = writeFile(`extenders:
+- urlPrefix: ` + server.URL + `/scheduler
+  prioritizeVerb: prioritize
+`)
+	if err := LoadExtenders(path); err == nil {
+		t.Error("Expected error for prioritize verb without weight")
+	}
+	if err := LoadExtenders(writeFile("extenders:\n- urlPrefx: typo\n")); err == nil {
+		t.Error("Expected error for an unknown field")
+	}
+	if len(DefaultProfile.Extenders) != 1 {
+		t.Errorf("Expected the loaded extenders to be kept after errors, got %+v", DefaultProfile.Extenders)
+	}
+}
+
+func TestExtender(t *testing.T) {
+	var bound extender.ExtenderBindingArgs
+	mux := http.NewServeMux()
+	// License check: only nodes labeled licensed=true may run the pod
+	mux.HandleFunc("/scheduler/filter", func(w http.ResponseWriter, r *http.Request) {
+		var args extender.ExtenderArgs
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := extender.ExtenderFilterResult{Nodes: &v1.NodeList{}, FailedNodes: extender.FailedNodesMap{}}
+		for _, node := range args.Nodes.Items {
+			if node.Labels["licensed"] == "true" {
+				result.Nodes.Items = append(result.Nodes.Items, node)
+			} else {
+				result.FailedNodes[node.Name] = "node(s) had no license seat"
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	// Prioritize speaks the raw upstream wire format, which has no json tags
+	mux.HandleFunc("/scheduler/prioritize", func(w http.ResponseWriter, r *http.Request) {
+		var args map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&args)
+		nodeNames, ok := args["NodeNames"].([]interface{})
+		if !ok {
+			http.Error(w, fmt.Sprintf("expected NodeNames in %v", args), http.StatusBadRequest)
+			return
+		}
+		result := []map[string]interface{}{}
+		for _, name := range nodeNames {
+			score := int64(0)
+			if name == "licensed-b" {
+				score = extender.MaxExtenderPriority
+			}
+			result = append(result, map[string]interface{}{"Host": name, "Score": score})
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	mux.HandleFunc("/scheduler/bind", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&bound)
+		json.NewEncoder(w).Encode(extender.ExtenderBindingResult{})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	clientset := fake.NewSimpleClientset(
+		readyNode("licensed-a", map[string]string{"licensed": "true"}),
+		readyNode("licensed-b", map[string]string{"licensed": "true"}),
+		readyNode("unlicensed", nil),
+	)
+	filterOnly := DefaultProfile
+	filterOnly.SchedulerName = "license-filter"
+	filterOnly.Extenders = []extender.Config{{URLPrefix: server.URL + "/scheduler", FilterVerb: "filter"}}
+	useProfile(t, filterOnly)
+
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "licensed-app", Namespace: "default"}}
+	pod.Spec.SchedulerName = "license-filter"
+	pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+			MatchFields: []v1.NodeSelectorRequirement{{Key: "metadata.name", Operator: v1.NodeSelectorOpIn, Values: []string{"unlicensed"}}},
+		}}},
+	}}
+	_, err := SelectBestNode(clientset, pod)
+	if err == nil || !strings.Contains(err.Error(), "1 node(s) had no license seat") {
+		t.Errorf("Expected the extender's reason in the fit error, got %v", err)
+	}
+	pod.Spec.Affinity = nil
+
+	// The prioritize and bind extender only gets node names
+	full := DefaultProfile
+	full.SchedulerName = "license-full"
+	full.Extenders = []extender.Config{
+		{URLPrefix: server.URL + "/scheduler", FilterVerb: "filter"},
+		{URLPrefix: server.URL + "/scheduler", PrioritizeVerb: "prioritize", BindVerb: "bind", Weight: 5, NodeCacheCapable: true},
+	}
+	useProfile(t, full)
+	pod.Spec.SchedulerName = "license-full"
+	node, err := SelectBestNode(clientset, pod)
+	if err != nil || node != "licensed-b" {
+		t.Fatalf("Expected licensed-b preferred by the extender, got %q (err: %v)", node, err)
+	}
+	if err := BindPod(clientset, pod, node); err != nil {
+		t.Fatalf("Bind through extender failed: %v", err)
+	}
+	if bound.PodName != "licensed-app" || bound.Node != "licensed-b" {
+		t.Errorf("Expected extender to bind licensed-app to licensed-b, got %+v", bound)
+	}
+
+	// An unreachable extender fails scheduling unless it is ignorable
+	down := DefaultProfile
+	down.SchedulerName = "license-down"
+	down.Extenders = []extender.Config{{URLPrefix: "http://127.0.0.1:1/scheduler", FilterVerb: "filter"}}
+	useProfile(t, down)
+	pod.Spec.SchedulerName = "license-down"
+	if _, err := SelectBestNode(clientset, pod); err == nil {
+		t.Errorf("Expected error from unreachable extender")
+	}
+	down.Extenders[0].Ignorable = true
+	useProfile(t, down)
+	if _, err := SelectBestNode(clientset, pod); err != nil {
+		t.Errorf("Expected ignorable extender to be skipped, got %v", err)
+	}
+
+	if _, err := extender.NewHTTPExtender(extender.Config{URLPrefix: server.URL, PrioritizeVerb: "prioritize"}); err == nil {
+		t.Errorf("Expected error for prioritize verb without weight")
+	}
+}
@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	ImageLocalityName = "ImageLocality"
+
+	imageLocalityPreScoreKey = "PreScore" + ImageLocalityName
+
+	mb int64 = 1024 * 1024
+	// Images smaller than this are cheap to pull and do not raise the score
+	imageLocalityMinThreshold int64 = 23 * mb
+	// Image bytes above this per container no longer raise the score
+	imageLocalityMaxContainerThreshold int64 = 1000 * mb
+)
+
+// ImageLocality favors nodes that already have the pod's container images,
+// weighted by image size and scaled down for images present on few nodes
+type ImageLocality struct{}
+
+func (p *ImageLocality) Name() string { return ImageLocalityName }
+
+// imageState is an image present on a node with the number of nodes holding it
+type imageState struct {
+	size     int64
+	numNodes int
+}
+
+// imageLocalityState holds the images of every node, keyed by node name and image name
+type imageLocalityState struct {
+	nodeImages    map[string]map[string]*imageState
+	totalNumNodes int
+}
+
+// PreScore indexes the images of all nodes and counts on how many nodes each image is
+func (p *ImageLocality) PreScore(state *CycleState, pod *v1.Pod, nodeInfos []*NodeInfo) error {
+	s := &imageLocalityState{
+		nodeImages:    map[string]map[string]*imageState{},
+		totalNumNodes: len(state.NodeInfos),
+	}
+	shared := map[string]*imageState{}
+	for _, info := range state.NodeInfos {
+		images := map[string]*imageState{}
+		for _, image := range info.Node.Status.Images {
+			for _, name := range image.Names {
+				is, ok := shared[name]
+				if !ok {
+					is = &imageState{size: image.SizeBytes}
+					shared[name] = is
+				}
+				// The same image may be listed under several names on one node
+				if _, seen := images[name]; !seen {
+					is.numNodes++
+					images[name] = is
+				}
+			}
+		}
+		s.nodeImages[info.Node.Name] = images
+	}
+	state.Write(imageLocalityPreScoreKey, s)
+	return nil
+}
+
+func (p *ImageLocality) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, error) {
+	v, ok := state.Read(imageLocalityPreScoreKey)
+	if !ok {
+		return 0, fmt.Errorf("%s prescore state not found", ImageLocalityName)
+	}
+	s := v.(*imageLocalityState)
+	images := s.nodeImages[nodeInfo.Node.Name]
+
+	var sumScores int64
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		if is, ok := images[normalizedImageName(c.Image)]; ok {
+			sumScores += scaledImageScore(is, s.totalNumNodes)
+		}
+	}
+	return calculateImageLocalityPriority(sumScores, len(containers)), nil
+}
+
+// Helper to scale an image's size by the fraction of nodes that have it, so
+// images already spread over the cluster don't pull every pod to one node
+func scaledImageScore(is *imageState, totalNumNodes int) int64 {
+	spread := float64(is.numNodes) / float64(totalNumNodes)
+	return int64(float64(is.size) * spread)
+}
+
+// Helper to map the summed image scores onto [0, MaxNodeScore] between the thresholds
+func calculateImageLocalityPriority(sumScores int64, numContainers int) int64 {
+	if numContainers == 0 {
+		return 0
+	}
+	maxThreshold := imageLocalityMaxContainerThreshold * int64(numContainers)
+	if sumScores < imageLocalityMinThreshold {
+		sumScores = imageLocalityMinThreshold
+	} else if sumScores > maxThreshold {
+		sumScores = maxThreshold
+	}
+	return MaxNodeScore * (sumScores - imageLocalityMinThreshold) / (maxThreshold - imageLocalityMinThreshold)
+}
+
+// Helper to add the implicit :latest tag to images without tag or digest
+func normalizedImageName(name string) string {
+	if strings.LastIndex(name, ":") <= strings.LastIndex(name, "/") {
+		name = name + ":latest"
+	}
+	return name
+}
@@ -0,0 +1,449 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	InterPodAffinityName = "InterPodAffinity"
+
+	interPodAffinityPreFilterKey = "PreFilter" + InterPodAffinityName
+	interPodAffinityPreScoreKey  = "PreScore" + InterPodAffinityName
+	namespaceLabelsKey           = "NamespaceLabels"
+
+	// Weight given to required affinity terms of existing pods when scoring
+	hardPodAffinityWeight int64 = 1
+)
+
+// InterPodAffinity filters and scores nodes by the podAffinity and podAntiAffinity
+// of the pod and of the pods already bound to nodes
+type InterPodAffinity struct{}
+
+func (p *InterPodAffinity) Name() string { return InterPodAffinityName }
+
+// topologyPair is a single topology domain, e.g. zone=a
+type topologyPair struct {
+	key   string
+	value string
+}
+
+// affinityTerm is a PodAffinityTerm with its selectors resolved
+type affinityTerm struct {
+	namespaces        map[string]bool
+	namespaceSelector labels.Selector
+	selector          labels.Selector
+	topologyKey       string
+	weight            int64
+}
+
+// Helper to check whether the term selects the pod
+func (t *affinityTerm) matches(pod *v1.Pod, nsLabels map[string]map[string]string) bool {
+	if !t.namespaces[pod.Namespace] && !t.namespaceSelector.Matches(labels.Set(nsLabels[pod.Namespace])) {
+		return false
+	}
+	return t.selector.Matches(labels.Set(pod.Labels))
+}
+
+// Helper to resolve a PodAffinityTerm of pod into an affinityTerm. Without
+// namespaces and namespaceSelector the term applies to the pod's own namespace.
+func newAffinityTerm(pod *v1.Pod, term *v1.PodAffinityTerm, weight int64) (*affinityTerm, error) {
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	t := &affinityTerm{
+		namespaces:        map[string]bool{},
+		namespaceSelector: labels.Nothing(),
+		selector:          selector,
+		topologyKey:       term.TopologyKey,
+		weight:            weight,
+	}
+	for _, ns := range term.Namespaces {
+		t.namespaces[ns] = true
+	}
+	if term.NamespaceSelector != nil {
+		t.namespaceSelector, err = metav1.LabelSelectorAsSelector(term.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(term.Namespaces) == 0 {
+		t.namespaces[pod.Namespace] = true
+	}
+	return t, nil
+}
+
+// Helper to resolve a list of required terms
+func newAffinityTerms(pod *v1.Pod, terms []v1.PodAffinityTerm) ([]*affinityTerm, error) {
+	result := make([]*affinityTerm, 0, len(terms))
+	for i := range terms {
+		t, err := newAffinityTerm(pod, &terms[i], hardPodAffinityWeight)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// Helper to resolve a list of preferred terms
+func newWeightedAffinityTerms(pod *v1.Pod, terms []v1.WeightedPodAffinityTerm) ([]*affinityTerm, error) {
+	result := make([]*affinityTerm, 0, len(terms))
+	for i := range terms {
+		t, err := newAffinityTerm(pod, &terms[i].PodAffinityTerm, int64(terms[i].Weight))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// podAffinityTerms holds the resolved inter-pod affinity terms of one pod
+type podAffinityTerms struct {
+	requiredAffinity      []*affinityTerm
+	requiredAntiAffinity  []*affinityTerm
+	preferredAffinity     []*affinityTerm
+	preferredAntiAffinity []*affinityTerm
+}
+
+// Helper to resolve all inter-pod affinity terms of a pod
+func getPodAffinityTerms(pod *v1.Pod) (*podAffinityTerms, error) {
+	terms := &podAffinityTerms{}
+	affinity := pod.Spec.Affinity
+	if affinity == nil {
+		return terms, nil
+	}
+	var err error
+	if pa := affinity.PodAffinity; pa != nil {
+		if terms.requiredAffinity, err = newAffinityTerms(pod, pa.RequiredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return nil, err
+		}
+		if terms.preferredAffinity, err = newWeightedAffinityTerms(pod, pa.PreferredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return nil, err
+		}
+	}
+	if paa := affinity.PodAntiAffinity; paa != nil {
+		if terms.requiredAntiAffinity, err = newAffinityTerms(pod, paa.RequiredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return nil, err
+		}
+		if terms.preferredAntiAffinity, err = newWeightedAffinityTerms(pod, paa.PreferredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return nil, err
+		}
+	}
+	return terms, nil
+}
+
+// Helper to check whether a pod declares any inter-pod affinity
+func hasPodAffinity(pod *v1.Pod) bool {
+	affinity := pod.Spec.Affinity
+	return affinity != nil && (affinity.PodAffinity != nil || affinity.PodAntiAffinity != nil)
+}
+
+// Helper to check whether any pod bound to the nodes declares inter-pod affinity
+func hasExistingPodAffinity(nodeInfos []*NodeInfo) bool {
+	for _, info := range nodeInfos {
+		for _, existing := range info.Pods {
+			if hasPodAffinity(existing) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Helper to check whether an inter-pod affinity term of the pod uses a namespaceSelector
+func usesNamespaceSelector(pod *v1.Pod) bool {
+	affinity := pod.Spec.Affinity
+	if affinity == nil {
+		return false
+	}
+	terms := []v1.PodAffinityTerm{}
+	if pa := affinity.PodAffinity; pa != nil {
+		terms = append(terms, pa.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, w := range pa.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, w.PodAffinityTerm)
+		}
+	}
+	if paa := affinity.PodAntiAffinity; paa != nil {
+		terms = append(terms, paa.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, w := range paa.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, w.PodAffinityTerm)
+		}
+	}
+	for _, t := range terms {
+		if t.NamespaceSelector != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to get namespace labels only when a term of the pod or of an existing
+// pod uses a namespaceSelector, since other terms match on namespace names
+func getNamespaceLabelsIfSelected(state *CycleState, pod *v1.Pod) (map[string]map[string]string, error) {
+	if usesNamespaceSelector(pod) {
+		return getNamespaceLabels(state)
+	}
+	for _, info := range state.NodeInfos {
+		for _, existing := range info.Pods {
+			if usesNamespaceSelector(existing) {
+				return getNamespaceLabels(state)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// getNamespaceLabels lists namespace labels once per cycle for namespaceSelectors
+func getNamespaceLabels(state *CycleState) (map[string]map[string]string, error) {
+	if v, ok := state.Read(namespaceLabelsKey); ok {
+		return v.(map[string]map[string]string), nil
+	}
+	nsList, err := state.ClientSet.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nsLabels := make(map[string]map[string]string, len(nsList.Items))
+	for _, ns := range nsList.Items {
+		nsLabels[ns.Name] = ns.Labels
+	}
+	state.Write(namespaceLabelsKey, nsLabels)
+	return nsLabels, nil
+}
+
+// interPodAffinityFilterState counts matching pods per topology domain
+type interPodAffinityFilterState struct {
+	terms    *podAffinityTerms
+	nsLabels map[string]map[string]string
+	// Pods matching all required affinity terms of the incoming pod
+	affinityCounts map[topologyPair]int
+	// Pods matching a required anti-affinity term of the incoming pod
+	antiAffinityCounts map[topologyPair]int
+	// Existing pods whose required anti-affinity terms match the incoming pod
+	existingAntiAffinityCounts map[topologyPair]int
+}
+
+func (p *InterPodAffinity) PreFilter(state *CycleState, pod *v1.Pod) error {
+	s := &interPodAffinityFilterState{
+		terms:                      &podAffinityTerms{},
+		affinityCounts:             map[topologyPair]int{},
+		antiAffinityCounts:         map[topologyPair]int{},
+		existingAntiAffinityCounts: map[topologyPair]int{},
+	}
+	if !hasPodAffinity(pod) && !hasExistingPodAffinity(state.NodeInfos) {
+		// Nothing to count, every node passes
+		state.Write(interPodAffinityPreFilterKey, s)
+		return nil
+	}
+	terms, err := getPodAffinityTerms(pod)
+	if err != nil {
+		return err
+	}
+	nsLabels, err := getNamespaceLabelsIfSelected(state, pod)
+	if err != nil {
+		return err
+	}
+	s.terms = terms
+	s.nsLabels = nsLabels
+
+	for _, info := range state.NodeInfos {
+		nodeLabels := info.Node.Labels
+		for _, existing := range info.Pods {
+			// Anti-affinity of existing pods applies to the incoming pod
+			if existing.Spec.Affinity != nil && existing.Spec.Affinity.PodAntiAffinity != nil {
+				existingTerms, err := newAffinityTerms(existing, existing.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+				if err != nil {
+					continue
+				}
+				for _, t := range existingTerms {
+					if value, ok := nodeLabels[t.topologyKey]; ok && t.matches(pod, nsLabels) {
+						s.existingAntiAffinityCounts[topologyPair{t.topologyKey, value}]++
+					}
+				}
+			}
+
+			if len(terms.requiredAffinity) > 0 && podMatchesAllAffinityTerms(terms.requiredAffinity, existing, nsLabels) {
+				for _, t := range terms.requiredAffinity {
+					if value, ok := nodeLabels[t.topologyKey]; ok {
+						s.affinityCounts[topologyPair{t.topologyKey, value}]++
+					}
+				}
+			}
+			for _, t := range terms.requiredAntiAffinity {
+				if value, ok := nodeLabels[t.topologyKey]; ok && t.matches(existing, nsLabels) {
+					s.antiAffinityCounts[topologyPair{t.topologyKey, value}]++
+				}
+			}
+		}
+	}
+	state.Write(interPodAffinityPreFilterKey, s)
+	return nil
+}
+
+// Helper to check whether all terms select the pod
+func podMatchesAllAffinityTerms(terms []*affinityTerm, pod *v1.Pod, nsLabels map[string]map[string]string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+	for _, t := range terms {
+		if !t.matches(pod, nsLabels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *InterPodAffinity) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	v, ok := state.Read(interPodAffinityPreFilterKey)
+	if !ok {
+		return fmt.Errorf("%s prefilter state not found", InterPodAffinityName)
+	}
+	s := v.(*interPodAffinityFilterState)
+	nodeLabels := nodeInfo.Node.Labels
+
+	for tp := range s.existingAntiAffinityCounts {
+		if value, ok := nodeLabels[tp.key]; ok && value == tp.value {
+			return fmt.Errorf("node(s) didn't satisfy existing pods anti-affinity rules")
+		}
+	}
+
+	for _, t := range s.terms.requiredAntiAffinity {
+		if value, ok := nodeLabels[t.topologyKey]; ok && s.antiAffinityCounts[topologyPair{t.topologyKey, value}] > 0 {
+			return fmt.Errorf("node(s) didn't match pod anti-affinity rules")
+		}
+	}
+
+	if len(s.terms.requiredAffinity) > 0 {
+		podsExist := true
+		for _, t := range s.terms.requiredAffinity {
+			value, ok := nodeLabels[t.topologyKey]
+			if !ok {
+				return fmt.Errorf("node(s) didn't match pod affinity rules")
+			}
+			if s.affinityCounts[topologyPair{t.topologyKey, value}] <= 0 {
+				podsExist = false
+			}
+		}
+		if !podsExist {
+			// The first pod of a group that selects itself may go anywhere,
+			// as long as no matching pod exists yet
+			if len(s.affinityCounts) == 0 && podMatchesAllAffinityTerms(s.terms.requiredAffinity, pod, s.nsLabels) {
+				return nil
+			}
+			return fmt.Errorf("node(s) didn't match pod affinity rules")
+		}
+	}
+	return nil
+}
+
+// interPodAffinityScoreState holds the summed term weights per topology domain
+type interPodAffinityScoreState struct {
+	topologyScore map[string]map[string]int64
+}
+
+func (p *InterPodAffinity) PreScore(state *CycleState, pod *v1.Pod, nodeInfos []*NodeInfo) error {
+	s := &interPodAffinityScoreState{topologyScore: map[string]map[string]int64{}}
+	if !hasPodAffinity(pod) && !hasExistingPodAffinity(state.NodeInfos) {
+		state.Write(interPodAffinityPreScoreKey, s)
+		return nil
+	}
+	terms, err := getPodAffinityTerms(pod)
+	if err != nil {
+		return err
+	}
+	nsLabels, err := getNamespaceLabelsIfSelected(state, pod)
+	if err != nil {
+		return err
+	}
+	add := func(topologyKey, value string, weight int64) {
+		if s.topologyScore[topologyKey] == nil {
+			s.topologyScore[topologyKey] = map[string]int64{}
+		}
+		s.topologyScore[topologyKey][value] += weight
+	}
+
+	// Existing pods on all nodes count, not only on feasible ones
+	for _, info := range state.NodeInfos {
+		nodeLabels := info.Node.Labels
+		for _, existing := range info.Pods {
+			for _, t := range terms.preferredAffinity {
+				if value, ok := nodeLabels[t.topologyKey]; ok && t.matches(existing, nsLabels) {
+					add(t.topologyKey, value, t.weight)
+				}
+			}
+			for _, t := range terms.preferredAntiAffinity {
+				if value, ok := nodeLabels[t.topologyKey]; ok && t.matches(existing, nsLabels) {
+					add(t.topologyKey, value, -t.weight)
+				}
+			}
+
+			if !hasPodAffinity(existing) {
+				continue
+			}
+			existingTerms, err := getPodAffinityTerms(existing)
+			if err != nil {
+				continue
+			}
+			for _, group := range []struct {
+				terms []*affinityTerm
+				sign  int64
+			}{
+				{existingTerms.requiredAffinity, 1},
+				{existingTerms.preferredAffinity, 1},
+				{existingTerms.preferredAntiAffinity, -1},
+			} {
+				for _, t := range group.terms {
+					if value, ok := nodeLabels[t.topologyKey]; ok && t.matches(pod, nsLabels) {
+						add(t.topologyKey, value, group.sign*t.weight)
+					}
+				}
+			}
+		}
+	}
+	state.Write(interPodAffinityPreScoreKey, s)
+	return nil
+}
+
+func (p *InterPodAffinity) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, error) {
+	v, ok := state.Read(interPodAffinityPreScoreKey)
+	if !ok {
+		return 0, fmt.Errorf("%s prescore state not found", InterPodAffinityName)
+	}
+	s := v.(*interPodAffinityScoreState)
+	var score int64
+	for topologyKey, values := range s.topologyScore {
+		if value, ok := nodeInfo.Node.Labels[topologyKey]; ok {
+			score += values[value]
+		}
+	}
+	return score, nil
+}
+
+// NormalizeScore maps the raw scores, which may be negative, onto [0, MaxNodeScore]
+func (p *InterPodAffinity) NormalizeScore(state *CycleState, pod *v1.Pod, scores NodeScoreList) error {
+	if len(scores) == 0 {
+		return nil
+	}
+	minScore, maxScore := scores[0].Score, scores[0].Score
+	for _, s := range scores {
+		if s.Score < minScore {
+			minScore = s.Score
+		}
+		if s.Score > maxScore {
+			maxScore = s.Score
+		}
+	}
+	for i := range scores {
+		if maxScore == minScore {
+			scores[i].Score = 0
+			continue
+		}
+		scores[i].Score = MaxNodeScore * (scores[i].Score - minScore) / (maxScore - minScore)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MetricsAddr is the address the metrics endpoint listens on
+var MetricsAddr = ":9090"
+
+// QueueMetrics describes the pods waiting in a queue
+type QueueMetrics struct {
+	Queue       string
+	PendingPods int
+	// How long the longest waiting pod has been pending
+	MaxWait time.Duration
+}
+
+var (
+	metricsLock sync.RWMutex
+	// Taken at the end of every scheduling cycle, so the endpoint never reads
+	// queues while the scheduler changes them
+	queueMetricsSnapshot []QueueMetrics
+)
+
+// Helper to collect the metrics of the queue and every queue below it
+func collectQueueMetrics(q *Queue, now time.Time, result []QueueMetrics) []QueueMetrics {
+	m := QueueMetrics{Queue: q.Path, PendingPods: q.Pods.Len()}
+	if since, ok := q.Pods.OldestWaitingSince(); ok {
+		m.MaxWait = now.Sub(since)
+	}
+	result = append(result, m)
+	for _, child := range q.Children {
+		result = collectQueueMetrics(child, now, result)
+	}
+	return result
+}
+
+// Helper to refresh the snapshot served by MetricsHandler
+func updateQueueMetrics(now time.Time) {
+	snapshot := collectQueueMetrics(rootQueue, now, nil)
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Queue < snapshot[j].Queue })
+	metricsLock.Lock()
+	queueMetricsSnapshot = snapshot
+	metricsLock.Unlock()
+}
+
+// GetQueueMetrics returns the queue metrics of the last scheduling cycle
+func GetQueueMetrics() []QueueMetrics {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+	return append([]QueueMetrics(nil), queueMetricsSnapshot...)
+}
+
+// MetricsHandler serves the queue metrics in the Prometheus text format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics := GetQueueMetrics()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# HELP kubescheduler_queue_pending_pods Number of pods waiting in the queue.")
+		fmt.Fprintln(w, "# TYPE kubescheduler_queue_pending_pods gauge")
+		for _, m := range metrics {
+			fmt.Fprintf(w, "kubescheduler_queue_pending_pods{queue=%q} %d\n", m.Queue, m.PendingPods)
+		}
+		fmt.Fprintln(w, "# HELP kubescheduler_queue_max_wait_seconds Time the longest waiting pod of the queue has been pending.")
+		fmt.Fprintln(w, "# TYPE kubescheduler_queue_max_wait_seconds gauge")
+		for _, m := range metrics {
+			fmt.Fprintf(w, "kubescheduler_queue_max_wait_seconds{queue=%q} %g\n", m.Queue, m.MaxWait.Seconds())
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Field key supported in NodeSelectorTerm.MatchFields
+const nodeFieldSelectorKeyNodeName = "metadata.name"
+
+// podMatchesNodeSelectorAndAffinity checks spec.nodeSelector and the required
+// node affinity of the pod against the node
+func podMatchesNodeSelectorAndAffinity(pod *v1.Pod, node *v1.Node) error {
+	for key, value := range pod.Spec.NodeSelector {
+		if nodeValue, ok := node.Labels[key]; !ok || nodeValue != value {
+			return fmt.Errorf("node(s) didn't match pod's node selector")
+		}
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil {
+		return nil
+	}
+	required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil {
+		return nil
+	}
+	if !nodeMatchesNodeSelectorTerms(node, required.NodeSelectorTerms) {
+		return fmt.Errorf("node(s) didn't match pod's node affinity")
+	}
+	return nil
+}
+
+// nodeMatchesNodeSelectorTerms returns true if the node matches any of the terms.
+// Terms are ORed, while the requirements inside a term are ANDed.
+func nodeMatchesNodeSelectorTerms(node *v1.Node, terms []v1.NodeSelectorTerm) bool {
+	for _, term := range terms {
+		// An empty term matches no objects
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+		if !matchNodeSelectorRequirements(term.MatchExpressions, node.Labels) {
+			continue
+		}
+		fields := map[string]string{nodeFieldSelectorKeyNodeName: node.Name}
+		if !matchNodeFieldRequirements(term.MatchFields, fields) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// Helper to match all label requirements of a term against the node labels
+func matchNodeSelectorRequirements(reqs []v1.NodeSelectorRequirement, labels map[string]string) bool {
+	for _, req := range reqs {
+		if !matchNodeSelectorRequirement(req, labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper to match all field requirements of a term. Only metadata.name with
+// In/NotIn is supported, like upstream.
+func matchNodeFieldRequirements(reqs []v1.NodeSelectorRequirement, fields map[string]string) bool {
+	for _, req := range reqs {
+		if req.Key != nodeFieldSelectorKeyNodeName {
+			return false
+		}
+		if req.Operator != v1.NodeSelectorOpIn && req.Operator != v1.NodeSelectorOpNotIn {
+			return false
+		}
+		if len(req.Values) != 1 {
+			return false
+		}
+		if !matchNodeSelectorRequirement(req, fields) {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper to evaluate a single NodeSelectorRequirement
+func matchNodeSelectorRequirement(req v1.NodeSelectorRequirement, labels map[string]string) bool {
+	value, exists := labels[req.Key]
+	switch req.Operator {
+	case v1.NodeSelectorOpIn:
+		if !exists {
+			return false
+		}
+		return containsString(req.Values, value)
+	case v1.NodeSelectorOpNotIn:
+		if len(req.Values) == 0 {
+			return false
+		}
+		return !exists || !containsString(req.Values, value)
+	case v1.NodeSelectorOpExists:
+		return exists && len(req.Values) == 0
+	case v1.NodeSelectorOpDoesNotExist:
+		return !exists && len(req.Values) == 0
+	case v1.NodeSelectorOpGt, v1.NodeSelectorOpLt:
+		if !exists || len(req.Values) != 1 {
+			return false
+		}
+		want, err := strconv.ParseInt(req.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		got, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		if req.Operator == v1.NodeSelectorOpGt {
+			return got > want
+		}
+		return got < want
+	}
+	return false
+}
+
+// Helper to check if a string is part of a list
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
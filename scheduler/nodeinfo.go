@@ -4,25 +4,215 @@ import (
 	"context"
 	"fmt"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
-func SelectBestNode(clientset kubernetes.Interface) (string, error) {
+// NodeInfo holds a node together with the pods already bound to it
+type NodeInfo struct {
+	Node *v1.Node
+	Pods []*v1.Pod
+	// Sum of the resource requests of all pods bound to the node
+	Requested v1.ResourceList
+}
+
+// Helper to build a NodeInfo for every node in the cluster
+func getNodeInfos(clientset kubernetes.Interface) ([]*NodeInfo, error) {
 	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
-	if err != nil || len(nodes.Items) == 0 {
-		return "", fmt.Errorf("no nodes available")
+	if err != nil {
+		return nil, err
+	}
+	pods, err := clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*NodeInfo, 0, len(nodes.Items))
+	byName := make(map[string]*NodeInfo, len(nodes.Items))
+	for i := range nodes.Items {
+		info := &NodeInfo{
+			Node:      &nodes.Items[i],
+			Requested: v1.ResourceList{},
+		}
+		infos = append(infos, info)
+		byName[info.Node.Name] = info
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+		// Terminated pods no longer hold on to their requests
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		info, ok := byName[pod.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		info.Pods = append(info.Pods, pod)
+		info.Requested = addResourceLists(info.Requested, getPodResourceRequests(pod))
+	}
+	return infos, nil
+}
+
+// Taint keys the node lifecycle controller uses for node conditions
+const (
+	TaintNodeNotReady           = "node.kubernetes.io/not-ready"
+	TaintNodeUnreachable        = "node.kubernetes.io/unreachable"
+	TaintNodeUnschedulable      = "node.kubernetes.io/unschedulable"
+	TaintNodeMemoryPressure     = "node.kubernetes.io/memory-pressure"
+	TaintNodeDiskPressure       = "node.kubernetes.io/disk-pressure"
+	TaintNodePIDPressure        = "node.kubernetes.io/pid-pressure"
+	TaintNodeNetworkUnavailable = "node.kubernetes.io/network-unavailable"
+)
+
+// Pressure conditions that rule out a node while True, with their taint keys
+var nodePressureConditions = []struct {
+	condition v1.NodeConditionType
+	taintKey  string
+	reason    string
+}{
+	{v1.NodeMemoryPressure, TaintNodeMemoryPressure, "node(s) had memory pressure"},
+	{v1.NodeDiskPressure, TaintNodeDiskPressure, "node(s) had disk pressure"},
+	{v1.NodePIDPressure, TaintNodePIDPressure, "node(s) had pid pressure"},
+	{v1.NodeNetworkUnavailable, TaintNodeNetworkUnavailable, "node(s) had unavailable network"},
+}
+
+// Helper to check the node conditions. A bad condition is ignored when the pod
+// tolerates the matching node.kubernetes.io/* NoSchedule taint.
+func checkNodeConditions(node *v1.Node, tolerations []v1.Toleration) error {
+	tolerates := func(key string) bool {
+		return toleratesTaint(tolerations, &v1.Taint{Key: key, Effect: v1.TaintEffectNoSchedule})
+	}
+
+	// A node that never reported Ready counts as not ready
+	ready := v1.ConditionFalse
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			ready = cond.Status
+		}
+	}
+	switch ready {
+	case v1.ConditionTrue:
+	case v1.ConditionUnknown:
+		if !tolerates(TaintNodeUnreachable) {
+			return fmt.Errorf("node(s) were unreachable")
+		}
+	default:
+		if !tolerates(TaintNodeNotReady) {
+			return fmt.Errorf("node(s) were not ready")
+		}
 	}
 
-	// Pick first ready node (simplified)
-	for _, node := range nodes.Items {
-		fmt.Print("Checking node: ", node.Name, "\n")
+	for _, pc := range nodePressureConditions {
 		for _, cond := range node.Status.Conditions {
-			if cond.Type == "Ready" && cond.Status == "True" {
-				return node.Name, nil
+			if cond.Type == pc.condition && cond.Status == v1.ConditionTrue && !tolerates(pc.taintKey) {
+				return fmt.Errorf("%s", pc.reason)
 			}
 		}
 	}
+	return nil
+}
+
+// Helper to check whether podReq fits into the node's unrequested allocatable
+// resources and the node's allocatable pod count
+func fitsNode(podReq v1.ResourceList, info *NodeInfo) error {
+	// Nodes that report a pod limit must have room for one more pod
+	if allowedPods, ok := info.Node.Status.Allocatable[v1.ResourcePods]; ok {
+		if int64(len(info.Pods))+1 > allowedPods.Value() {
+			return fmt.Errorf("too many pods")
+		}
+	}
+	for name, reqQty := range podReq {
+		if reqQty.IsZero() {
+			continue
+		}
+		free := info.Node.Status.Allocatable[name].DeepCopy()
+		free.Sub(info.Requested[name])
+		if reqQty.Cmp(free) > 0 {
+			return fmt.Errorf("insufficient %s", name)
+		}
+	}
+	return nil
+}
+
+// Helper to find the first NoSchedule/NoExecute taint on the node that the pod does not tolerate
+func findUntoleratedTaint(taints []v1.Taint, tolerations []v1.Toleration) (*v1.Taint, bool) {
+	for i := range taints {
+		taint := &taints[i]
+		// PreferNoSchedule is only a soft preference and never filters a node
+		if taint.Effect != v1.TaintEffectNoSchedule && taint.Effect != v1.TaintEffectNoExecute {
+			continue
+		}
+		if !toleratesTaint(tolerations, taint) {
+			return taint, true
+		}
+	}
+	return nil, false
+}
+
+// Helper to check whether any toleration matches the taint
+func toleratesTaint(tolerations []v1.Toleration, taint *v1.Taint) bool {
+	for i := range tolerations {
+		if tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectBestNode runs the filter plugins of the pod's profile against every node
+// and returns the feasible node with the highest weighted score
+func SelectBestNode(clientset kubernetes.Interface, pod *v1.Pod) (string, error) {
+	nodeInfos, err := getNodeInfos(clientset)
+	if err != nil || len(nodeInfos) == 0 {
+		return "", fmt.Errorf("no nodes available")
+	}
+	fwk, err := frameworkForPod(pod)
+	if err != nil {
+		return "", err
+	}
+	state := &CycleState{ClientSet: clientset, NodeInfos: nodeInfos}
+	if err := fwk.RunPreFilterPlugins(state, pod); err != nil {
+		return "", err
+	}
+
+	fitErr := &FitError{Pod: pod, NumAllNodes: len(nodeInfos), NodeReasons: map[string]string{}}
+	feasible := make([]*NodeInfo, 0, len(nodeInfos))
+	for _, info := range nodeInfos {
+		fmt.Print("Checking node: ", info.Node.Name, "\n")
+		if err := fwk.RunFilterPlugins(state, pod, info); err != nil {
+			fmt.Printf("Node %s skipped: %v\n", info.Node.Name, err)
+			fitErr.NodeReasons[info.Node.Name] = err.Error()
+			continue
+		}
+		feasible = append(feasible, info)
+	}
+	feasible, err = fwk.RunExtenderFilters(pod, feasible, fitErr)
+	if err != nil {
+		return "", err
+	}
+	if len(feasible) == 0 {
+		return "", fitErr
+	}
+	if len(feasible) == 1 || !fwk.HasScorePlugins() {
+		return feasible[0].Node.Name, nil
+	}
 
-	return "", fmt.Errorf("no ready nodes")
+	scores, err := fwk.RunScorePlugins(state, pod, feasible)
+	if err != nil {
+		return "", err
+	}
+	fwk.RunExtenderPrioritize(pod, feasible, scores)
+	// Ties go to the node listed first
+	best := 0
+	for i := range scores {
+		if scores[i].Score > scores[best].Score {
+			best = i
+		}
+	}
+	return scores[best].Name, nil
 }
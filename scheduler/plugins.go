@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Names of the in-tree plugins
+const (
+	NodeConditionsName    = "NodeConditions"
+	NodeUnschedulableName = "NodeUnschedulable"
+	TaintTolerationName   = "TaintToleration"
+	NodeAffinityName      = "NodeAffinity"
+	NodeResourcesFitName  = "NodeResourcesFit"
+	NodePortsName         = "NodePorts"
+)
+
+// NodeConditions filters out nodes that are not Ready or report memory, disk
+// or PID pressure or an unavailable network
+type NodeConditions struct{}
+
+func (p *NodeConditions) Name() string { return NodeConditionsName }
+
+func (p *NodeConditions) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	return checkNodeConditions(nodeInfo.Node, pod.Spec.Tolerations)
+}
+
+// NodeUnschedulable filters out cordoned nodes unless the pod tolerates the unschedulable taint
+type NodeUnschedulable struct{}
+
+func (p *NodeUnschedulable) Name() string { return NodeUnschedulableName }
+
+func (p *NodeUnschedulable) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	if !nodeInfo.Node.Spec.Unschedulable {
+		return nil
+	}
+	taint := &v1.Taint{Key: TaintNodeUnschedulable, Effect: v1.TaintEffectNoSchedule}
+	if toleratesTaint(pod.Spec.Tolerations, taint) {
+		return nil
+	}
+	return fmt.Errorf("node(s) were unschedulable")
+}
+
+// TaintToleration filters out nodes with NoSchedule/NoExecute taints the pod does not tolerate
+type TaintToleration struct{}
+
+func (p *TaintToleration) Name() string { return TaintTolerationName }
+
+func (p *TaintToleration) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	if taint, found := findUntoleratedTaint(nodeInfo.Node.Spec.Taints, pod.Spec.Tolerations); found {
+		return fmt.Errorf("node(s) had untolerated taint {%s}", taint.ToString())
+	}
+	return nil
+}
+
+// NodeAffinity filters out nodes that do not match the pod's nodeSelector or required node affinity
+type NodeAffinity struct{}
+
+func (p *NodeAffinity) Name() string { return NodeAffinityName }
+
+func (p *NodeAffinity) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	return podMatchesNodeSelectorAndAffinity(pod, nodeInfo.Node)
+}
+
+// NodeResourcesFit filters out nodes without enough unrequested allocatable resources
+// and scores nodes with the scoring strategy of the pod's queue
+type NodeResourcesFit struct{}
+
+func (p *NodeResourcesFit) Name() string { return NodeResourcesFitName }
+
+// PreFilter rejects pods with fractional or overcommitted extended resources
+func (p *NodeResourcesFit) PreFilter(state *CycleState, pod *v1.Pod) error {
+	return validateExtendedResources(pod)
+}
+
+func (p *NodeResourcesFit) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	return fitsNode(getPodResourceRequests(pod), nodeInfo)
+}
+
+// NodePorts filters out nodes where a host port of the pod is already in use
+type NodePorts struct{}
+
+func (p *NodePorts) Name() string { return NodePortsName }
+
+func (p *NodePorts) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	wanted := getHostPorts(pod)
+	if len(wanted) == 0 {
+		return nil
+	}
+	for _, existing := range nodeInfo.Pods {
+		for _, used := range getHostPorts(existing) {
+			for _, port := range wanted {
+				if hostPortsConflict(port, used) {
+					return fmt.Errorf("node(s) didn't have free ports for the requested pod ports")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Helper to collect the container ports of a pod that are bound to a host port.
+// Restartable (sidecar) init containers keep running next to the app
+// containers, so their ports count too.
+func getHostPorts(pod *v1.Pod) []v1.ContainerPort {
+	var ports []v1.ContainerPort
+	containers := append([]v1.Container{}, pod.Spec.Containers...)
+	for _, c := range pod.Spec.InitContainers {
+		if c.RestartPolicy != nil && *c.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			containers = append(containers, c)
+		}
+	}
+	for _, c := range containers {
+		for _, port := range c.Ports {
+			if port.HostPort > 0 {
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports
+}
+
+// Helper to check whether two host ports collide. An empty protocol means TCP
+// and an empty or 0.0.0.0 host IP overlaps with every address.
+func hostPortsConflict(a, b v1.ContainerPort) bool {
+	if a.HostPort != b.HostPort {
+		return false
+	}
+	protocolA, protocolB := a.Protocol, b.Protocol
+	if protocolA == "" {
+		protocolA = v1.ProtocolTCP
+	}
+	if protocolB == "" {
+		protocolB = v1.ProtocolTCP
+	}
+	if protocolA != protocolB {
+		return false
+	}
+	isWildcard := func(ip string) bool { return ip == "" || ip == "0.0.0.0" }
+	return isWildcard(a.HostIP) || isWildcard(b.HostIP) || a.HostIP == b.HostIP
+}
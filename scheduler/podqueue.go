@@ -0,0 +1,336 @@
+package scheduler
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+)
+
+// Queue policies decide the order pods leave a queue and, on parent queues,
+// which child queue is served next
+const (
+	PolicyFIFO     = "fifo"
+	PolicyPriority = "priority"
+	// PolicyFair serves the child queue furthest below its guaranteed share first
+	PolicyFair = "fair"
+	// PolicyDRF serves the child queue with the smallest dominant resource share first
+	PolicyDRF = "drf"
+	// PolicyWRR serves child queues in proportion to their weights
+	PolicyWRR = "wrr"
+	// PolicyDeadline schedules the pod with the earliest deadline first
+	PolicyDeadline = "deadline"
+)
+
+// DeadlineAnnotation holds the RFC3339 time by which a pod should be scheduled
+const DeadlineAnnotation = "scheduler.kubernetes.io/deadline"
+
+// Helper to check whether a policy name is supported
+func isValidPolicy(policy string) bool {
+	switch policy {
+	case PolicyFIFO, PolicyPriority, PolicyFair, PolicyDRF, PolicyWRR, PolicyDeadline:
+		return true
+	}
+	return false
+}
+
+// Enqueue order shared by all queues, so pods of different queues can be compared
+var podQueueSeq int64
+
+// queuedPod is a pending pod with the order it was first enqueued in
+type queuedPod struct {
+	pod *v1.Pod
+	seq int64
+	// Parsed DeadlineAnnotation, nil when the pod has none
+	deadline *time.Time
+	// When the pod started waiting, used for aging and wait metrics
+	waitingSince time.Time
+	// Position in the heap, kept up to date by Swap
+	index int
+}
+
+// PodQueue is a heap of pending pods ordered by the queue policy. A pod is
+// queued at most once, identified by namespace and name.
+type PodQueue struct {
+	policy string
+	// Priority points a pod gains per minute of waiting under the priority policy
+	agingRate int
+	items     []*queuedPod
+	byKey     map[string]*queuedPod
+}
+
+func newPodQueue(policy string, agingRate int) *PodQueue {
+	return &PodQueue{policy: policy, agingRate: agingRate, byKey: map[string]*queuedPod{}}
+}
+
+func podKey(pod *v1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+// heap.Interface, use Add, Pop and Peek instead
+func (q *PodQueue) Len() int { return len(q.items) }
+
+func (q *PodQueue) Less(i, j int) bool {
+	return lessQueuedPod(q.policy, q.agingRate, q.items[i], q.items[j])
+}
+
+// Helper to order two pending pods by a policy. Policies that don't order
+// pods themselves, such as fair, fall back to FIFO. With aging, the priority
+// policy compares priority plus agingRate points per minute waited.
+func lessQueuedPod(policy string, agingRate int, a, b *queuedPod) bool {
+	if policy == PolicyDeadline && (a.deadline != nil || b.deadline != nil) {
+		// Pods without a deadline go after all pods with one
+		if a.deadline == nil || b.deadline == nil {
+			return a.deadline != nil
+		}
+		if !a.deadline.Equal(*b.deadline) {
+			return a.deadline.Before(*b.deadline)
+		}
+	}
+	if policy == PolicyPriority {
+		pa, pb := agedPriorityKey(a, agingRate), agedPriorityKey(b, agingRate)
+		if pa != pb {
+			return pa > pb
+		}
+		ta, tb := a.pod.CreationTimestamp, b.pod.CreationTimestamp
+		if !ta.Equal(&tb) {
+			return ta.Before(&tb)
+		}
+	}
+	return a.seq < b.seq
+}
+
+func (q *PodQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *PodQueue) Push(x interface{}) {
+	item := x.(*queuedPod)
+	item.index = len(q.items)
+	q.items = append(q.items, item)
+}
+
+func (q *PodQueue) Pop() interface{} {
+	last := len(q.items) - 1
+	item := q.items[last]
+	q.items[last] = nil
+	q.items = q.items[:last]
+	return item
+}
+
+// Add queues the pod. A pod that is already queued is updated in place and
+// keeps its position in line.
+func (q *PodQueue) Add(pod *v1.Pod) {
+	key := podKey(pod)
+	if item, ok := q.byKey[key]; ok {
+		item.pod = pod
+		item.deadline = getPodDeadline(pod)
+		heap.Fix(q, item.index)
+		return
+	}
+	item := &queuedPod{pod: pod, seq: podQueueSeq, deadline: getPodDeadline(pod), waitingSince: podWaitingSince(pod)}
+	podQueueSeq++
+	q.byKey[key] = item
+	heap.Push(q, item)
+}
+
+// Peek returns the next pod without removing it
+func (q *PodQueue) Peek() *v1.Pod {
+	if len(q.items) == 0 {
+		return nil
+	}
+	return q.items[0].pod
+}
+
+func (q *PodQueue) peekItem() *queuedPod {
+	if len(q.items) == 0 {
+		return nil
+	}
+	return q.items[0]
+}
+
+// PopPod removes and returns the next pod
+func (q *PodQueue) PopPod() *v1.Pod {
+	if len(q.items) == 0 {
+		return nil
+	}
+	item := heap.Pop(q).(*queuedPod)
+	delete(q.byKey, podKey(item.pod))
+	return item.pod
+}
+
+// Delete removes the pod if it is queued
+func (q *PodQueue) Delete(pod *v1.Pod) {
+	item, ok := q.byKey[podKey(pod)]
+	if !ok {
+		return
+	}
+	heap.Remove(q, item.index)
+	delete(q.byKey, podKey(pod))
+}
+
+// SetPolicy changes the ordering and re-sorts the pods already queued
+func (q *PodQueue) SetPolicy(policy string) {
+	if q.policy == policy {
+		return
+	}
+	q.policy = policy
+	heap.Init(q)
+}
+
+// SetAgingRate changes how fast waiting pods gain priority and re-sorts the queue
+func (q *PodQueue) SetAgingRate(agingRate int) {
+	if q.agingRate == agingRate {
+		return
+	}
+	q.agingRate = agingRate
+	heap.Init(q)
+}
+
+// Reorder re-sorts the queue after the priority of queued pods changed
+func (q *PodQueue) Reorder() {
+	heap.Init(q)
+}
+
+// Helper to re-sort the pods of the queue and every queue below it
+func reorderQueuedPods(q *Queue) {
+	q.Pods.Reorder()
+	for _, child := range q.Children {
+		reorderQueuedPods(child)
+	}
+}
+
+// OldestWaitingSince returns when the longest waiting pod started waiting,
+// and false when the queue is empty
+func (q *PodQueue) OldestWaitingSince() (time.Time, bool) {
+	var oldest time.Time
+	for _, item := range q.items {
+		if oldest.IsZero() || item.waitingSince.Before(oldest) {
+			oldest = item.waitingSince
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
+
+// Helper to get when a pod started waiting: its creation, which survives
+// scheduler restarts and failed attempts, or now if it isn't set
+func podWaitingSince(pod *v1.Pod) time.Time {
+	if !pod.CreationTimestamp.IsZero() {
+		return pod.CreationTimestamp.Time
+	}
+	return time.Now()
+}
+
+// Helper to compute the aged priority of a pod as a key that doesn't change
+// over time. priority + rate*(now-since) orders pods the same way as
+// priority - rate*since, so the heap stays valid while pods wait.
+func agedPriorityKey(item *queuedPod, agingRate int) float64 {
+	key := float64(getPodPriority(item.pod))
+	if agingRate > 0 {
+		key -= float64(agingRate) * float64(item.waitingSince.Unix()) / 60
+	}
+	return key
+}
+
+// Ordered returns up to n pods in the order they would be dequeued
+func (q *PodQueue) Ordered(n int) []*v1.Pod {
+	items := append([]*queuedPod(nil), q.items...)
+	sort.Slice(items, func(i, j int) bool {
+		return lessQueuedPod(q.policy, q.agingRate, items[i], items[j])
+	})
+	if n < len(items) {
+		items = items[:n]
+	}
+	pods := make([]*v1.Pod, 0, len(items))
+	for _, item := range items {
+		pods = append(pods, item.pod)
+	}
+	return pods
+}
+
+// List returns the queued pods in no particular order
+func (q *PodQueue) List() []*v1.Pod {
+	pods := make([]*v1.Pod, 0, len(q.items))
+	for _, item := range q.items {
+		pods = append(pods, item.pod)
+	}
+	return pods
+}
+
+// Helper to parse the deadline annotation of a pod. Invalid values are logged
+// and ignored.
+func getPodDeadline(pod *v1.Pod) *time.Time {
+	value, ok := pod.Annotations[DeadlineAnnotation]
+	if !ok {
+		return nil
+	}
+	deadline, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		fmt.Printf("Ignoring invalid deadline %q of pod %s/%s: %v\n", value, pod.Namespace, pod.Name, err)
+		return nil
+	}
+	return &deadline
+}
+
+var (
+	priorityClassesLock sync.RWMutex
+	// Value of each PriorityClass, kept up to date by WatchPriorityClasses
+	priorityClasses = map[string]int32{}
+	// Name of the PriorityClass with globalDefault set, if any
+	globalDefaultPriorityClass string
+)
+
+// UpdatePriorityClass records the value of a created or updated PriorityClass.
+// Queued pods are re-sorted when the value or the global default changed.
+func UpdatePriorityClass(pc *schedulingv1.PriorityClass) {
+	priorityClassesLock.Lock()
+	old, existed := priorityClasses[pc.Name]
+	oldDefault := globalDefaultPriorityClass
+	priorityClasses[pc.Name] = pc.Value
+	if pc.GlobalDefault {
+		globalDefaultPriorityClass = pc.Name
+	} else if globalDefaultPriorityClass == pc.Name {
+		globalDefaultPriorityClass = ""
+	}
+	changed := !existed || old != pc.Value || oldDefault != globalDefaultPriorityClass
+	priorityClassesLock.Unlock()
+	fmt.Printf("PriorityClass %s has value %d\n", pc.Name, pc.Value)
+	if changed {
+		reorderQueuedPods(rootQueue)
+	}
+}
+
+// DeletePriorityClass forgets a deleted PriorityClass and re-sorts queued pods
+func DeletePriorityClass(pc *schedulingv1.PriorityClass) {
+	priorityClassesLock.Lock()
+	delete(priorityClasses, pc.Name)
+	if globalDefaultPriorityClass == pc.Name {
+		globalDefaultPriorityClass = ""
+	}
+	priorityClassesLock.Unlock()
+	reorderQueuedPods(rootQueue)
+}
+
+// Helper to get the priority of a pod. spec.priority is filled in by the
+// Priority admission plugin; if it is missing the value is looked up from the
+// pod's PriorityClass, or the global default class, and is 0 otherwise.
+func getPodPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority != nil {
+		return *pod.Spec.Priority
+	}
+	priorityClassesLock.RLock()
+	defer priorityClassesLock.RUnlock()
+	if value, ok := priorityClasses[pod.Spec.PriorityClassName]; ok && pod.Spec.PriorityClassName != "" {
+		return value
+	}
+	if pod.Spec.PriorityClassName == "" && globalDefaultPriorityClass != "" {
+		return priorityClasses[globalDefaultPriorityClass]
+	}
+	return 0
+}
@@ -0,0 +1,305 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	PodTopologySpreadName = "PodTopologySpread"
+
+	podTopologySpreadPreFilterKey = "PreFilter" + PodTopologySpreadName
+	podTopologySpreadPreScoreKey  = "PreScore" + PodTopologySpreadName
+)
+
+// PodTopologySpread filters nodes by the DoNotSchedule topology spread constraints
+// of the pod and scores them by its ScheduleAnyway constraints
+type PodTopologySpread struct{}
+
+func (p *PodTopologySpread) Name() string { return PodTopologySpreadName }
+
+// spreadConstraint is a TopologySpreadConstraint with its selector resolved
+type spreadConstraint struct {
+	maxSkew            int32
+	minDomains         int32
+	topologyKey        string
+	selector           labels.Selector
+	nodeAffinityPolicy v1.NodeInclusionPolicy
+	nodeTaintsPolicy   v1.NodeInclusionPolicy
+}
+
+// Helper to resolve the constraints of the pod with the given whenUnsatisfiable action
+func getSpreadConstraints(pod *v1.Pod, action v1.UnsatisfiableConstraintAction) ([]*spreadConstraint, error) {
+	var result []*spreadConstraint
+	for _, c := range pod.Spec.TopologySpreadConstraints {
+		if c.WhenUnsatisfiable != action {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(c.LabelSelector)
+		if err != nil {
+			return nil, err
+		}
+		// matchLabelKeys narrows the selector to pods sharing the incoming pod's values
+		if len(c.MatchLabelKeys) > 0 {
+			matchLabels := labels.Set{}
+			for _, key := range c.MatchLabelKeys {
+				if value, ok := pod.Labels[key]; ok {
+					matchLabels[key] = value
+				}
+			}
+			reqs, _ := labels.SelectorFromSet(matchLabels).Requirements()
+			selector = selector.Add(reqs...)
+		}
+		sc := &spreadConstraint{
+			maxSkew:            c.MaxSkew,
+			minDomains:         1,
+			topologyKey:        c.TopologyKey,
+			selector:           selector,
+			nodeAffinityPolicy: v1.NodeInclusionPolicyHonor,
+			nodeTaintsPolicy:   v1.NodeInclusionPolicyIgnore,
+		}
+		if c.MinDomains != nil {
+			sc.minDomains = *c.MinDomains
+		}
+		if c.NodeAffinityPolicy != nil {
+			sc.nodeAffinityPolicy = *c.NodeAffinityPolicy
+		}
+		if c.NodeTaintsPolicy != nil {
+			sc.nodeTaintsPolicy = *c.NodeTaintsPolicy
+		}
+		result = append(result, sc)
+	}
+	return result, nil
+}
+
+// Helper to check whether a node takes part in spreading for the constraint,
+// according to its nodeAffinityPolicy and nodeTaintsPolicy
+func (c *spreadConstraint) nodeIsEligible(pod *v1.Pod, node *v1.Node) bool {
+	if c.nodeAffinityPolicy == v1.NodeInclusionPolicyHonor && podMatchesNodeSelectorAndAffinity(pod, node) != nil {
+		return false
+	}
+	if c.nodeTaintsPolicy == v1.NodeInclusionPolicyHonor {
+		if _, found := findUntoleratedTaint(node.Spec.Taints, pod.Spec.Tolerations); found {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper to count the pods on a node that the constraint selects
+func (c *spreadConstraint) countMatchingPods(pod *v1.Pod, info *NodeInfo) int {
+	count := 0
+	for _, existing := range info.Pods {
+		if existing.Namespace != pod.Namespace || existing.DeletionTimestamp != nil {
+			continue
+		}
+		if c.selector.Matches(labels.Set(existing.Labels)) {
+			count++
+		}
+	}
+	return count
+}
+
+// Helper to check that the node has every topology key used by the constraints
+func nodeHasTopologyKeys(node *v1.Node, constraints []*spreadConstraint) bool {
+	for _, c := range constraints {
+		if _, ok := node.Labels[c.topologyKey]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// podTopologySpreadFilterState holds the matching pod count per domain of every hard constraint
+type podTopologySpreadFilterState struct {
+	constraints []*spreadConstraint
+	// Pod counts per domain value, one map per constraint
+	domainCounts []map[string]int
+}
+
+// Helper for the smallest pod count over the domains of constraint i. With fewer
+// domains than minDomains the global minimum is treated as 0.
+func (s *podTopologySpreadFilterState) minMatch(i int) int {
+	counts := s.domainCounts[i]
+	if int32(len(counts)) < s.constraints[i].minDomains {
+		return 0
+	}
+	minCount := math.MaxInt32
+	for _, count := range counts {
+		if count < minCount {
+			minCount = count
+		}
+	}
+	if minCount == math.MaxInt32 {
+		return 0
+	}
+	return minCount
+}
+
+func (p *PodTopologySpread) PreFilter(state *CycleState, pod *v1.Pod) error {
+	constraints, err := getSpreadConstraints(pod, v1.DoNotSchedule)
+	if err != nil {
+		return err
+	}
+	s := &podTopologySpreadFilterState{
+		constraints:  constraints,
+		domainCounts: make([]map[string]int, len(constraints)),
+	}
+	for i := range constraints {
+		s.domainCounts[i] = map[string]int{}
+	}
+	for _, info := range state.NodeInfos {
+		if !nodeHasTopologyKeys(info.Node, constraints) {
+			continue
+		}
+		for i, c := range constraints {
+			if !c.nodeIsEligible(pod, info.Node) {
+				continue
+			}
+			domain := info.Node.Labels[c.topologyKey]
+			s.domainCounts[i][domain] += c.countMatchingPods(pod, info)
+		}
+	}
+	state.Write(podTopologySpreadPreFilterKey, s)
+	return nil
+}
+
+func (p *PodTopologySpread) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	v, ok := state.Read(podTopologySpreadPreFilterKey)
+	if !ok {
+		return fmt.Errorf("%s prefilter state not found", PodTopologySpreadName)
+	}
+	s := v.(*podTopologySpreadFilterState)
+	for i, c := range s.constraints {
+		domain, ok := nodeInfo.Node.Labels[c.topologyKey]
+		if !ok {
+			return fmt.Errorf("node(s) didn't match pod topology spread constraints (missing required label)")
+		}
+		selfMatch := 0
+		if c.selector.Matches(labels.Set(pod.Labels)) {
+			selfMatch = 1
+		}
+		skew := s.domainCounts[i][domain] + selfMatch - s.minMatch(i)
+		if skew > int(c.maxSkew) {
+			return fmt.Errorf("node(s) didn't match pod topology spread constraints")
+		}
+	}
+	return nil
+}
+
+// podTopologySpreadScoreState holds the matching pod count per domain of every soft constraint
+type podTopologySpreadScoreState struct {
+	constraints  []*spreadConstraint
+	domainCounts []map[string]int
+	// Weight of each constraint, larger for topologies with more domains
+	weights []float64
+	// Feasible nodes that lack a topology key and therefore get the lowest score
+	ignoredNodes map[string]bool
+}
+
+func (p *PodTopologySpread) PreScore(state *CycleState, pod *v1.Pod, nodeInfos []*NodeInfo) error {
+	constraints, err := getSpreadConstraints(pod, v1.ScheduleAnyway)
+	if err != nil {
+		return err
+	}
+	s := &podTopologySpreadScoreState{
+		constraints:  constraints,
+		domainCounts: make([]map[string]int, len(constraints)),
+		weights:      make([]float64, len(constraints)),
+		ignoredNodes: map[string]bool{},
+	}
+	for i := range constraints {
+		s.domainCounts[i] = map[string]int{}
+	}
+	// Only domains of feasible nodes are candidates
+	for _, info := range nodeInfos {
+		if !nodeHasTopologyKeys(info.Node, constraints) {
+			s.ignoredNodes[info.Node.Name] = true
+			continue
+		}
+		for i, c := range constraints {
+			s.domainCounts[i][info.Node.Labels[c.topologyKey]] = 0
+		}
+	}
+	for i := range constraints {
+		s.weights[i] = math.Log(float64(len(s.domainCounts[i]) + 2))
+	}
+	// Existing pods are counted on every node in those domains
+	for _, info := range state.NodeInfos {
+		if !nodeHasTopologyKeys(info.Node, constraints) {
+			continue
+		}
+		for i, c := range constraints {
+			domain := info.Node.Labels[c.topologyKey]
+			if _, ok := s.domainCounts[i][domain]; !ok || !c.nodeIsEligible(pod, info.Node) {
+				continue
+			}
+			s.domainCounts[i][domain] += c.countMatchingPods(pod, info)
+		}
+	}
+	state.Write(podTopologySpreadPreScoreKey, s)
+	return nil
+}
+
+// Score returns the weighted number of matching pods in the node's domains;
+// NormalizeScore turns it around so fewer pods means a higher score
+func (p *PodTopologySpread) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, error) {
+	v, ok := state.Read(podTopologySpreadPreScoreKey)
+	if !ok {
+		return 0, fmt.Errorf("%s prescore state not found", PodTopologySpreadName)
+	}
+	s := v.(*podTopologySpreadScoreState)
+	if s.ignoredNodes[nodeInfo.Node.Name] {
+		return 0, nil
+	}
+	var score float64
+	for i, c := range s.constraints {
+		count := s.domainCounts[i][nodeInfo.Node.Labels[c.topologyKey]]
+		score += float64(count)*s.weights[i] + float64(c.maxSkew-1)
+	}
+	return int64(math.Round(score)), nil
+}
+
+func (p *PodTopologySpread) NormalizeScore(state *CycleState, pod *v1.Pod, scores NodeScoreList) error {
+	v, ok := state.Read(podTopologySpreadPreScoreKey)
+	if !ok {
+		return fmt.Errorf("%s prescore state not found", PodTopologySpreadName)
+	}
+	s := v.(*podTopologySpreadScoreState)
+	if len(s.constraints) == 0 {
+		for i := range scores {
+			scores[i].Score = 0
+		}
+		return nil
+	}
+
+	var minScore int64 = math.MaxInt64
+	var maxScore int64
+	for _, score := range scores {
+		if s.ignoredNodes[score.Name] {
+			continue
+		}
+		if score.Score < minScore {
+			minScore = score.Score
+		}
+		if score.Score > maxScore {
+			maxScore = score.Score
+		}
+	}
+	for i := range scores {
+		if s.ignoredNodes[scores[i].Name] {
+			scores[i].Score = 0
+			continue
+		}
+		if maxScore == 0 {
+			scores[i].Score = MaxNodeScore
+			continue
+		}
+		scores[i].Score = MaxNodeScore * (maxScore + minScore - scores[i].Score) / maxScore
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// SelectLeafQueue descends the hierarchy from rootQueue and returns the queue
+// whose head pod should be scheduled next, or nil when no queue has pending
+// pods. At every level the parent's policy orders the children, and parents
+// without headroom are not descended into. Queues in skip are left out.
+func SelectLeafQueue(clusterTotal v1.ResourceList, skip map[*Queue]bool) *Queue {
+	return selectLeafQueue(rootQueue, clusterTotal, skip)
+}
+
+func selectLeafQueue(q *Queue, clusterTotal v1.ResourceList, skip map[*Queue]bool) *Queue {
+	if skip[q] {
+		return nil
+	}
+	if q.Parent != nil && !hasHeadroom(q, clusterTotal) {
+		return nil
+	}
+	children := make([]*Queue, 0, len(q.Children))
+	for _, child := range q.Children {
+		if hasPendingPods(child) {
+			children = append(children, child)
+		}
+	}
+	sortChildQueues(q, children, clusterTotal)
+	for _, child := range children {
+		if leaf := selectLeafQueue(child, clusterTotal, skip); leaf != nil {
+			if q.Config.Policy == PolicyWRR {
+				advanceWRR(children, child)
+			}
+			return leaf
+		}
+	}
+	// Pods can also be queued directly on a parent queue
+	if q.Pods.Len() > 0 {
+		return q
+	}
+	return nil
+}
+
+// Helper to order sibling queues by their parent's policy
+func sortChildQueues(parent *Queue, children []*Queue, clusterTotal v1.ResourceList) {
+	policy, agingRate := parent.Config.Policy, parent.Config.AgingRate
+	switch policy {
+	case PolicyFair, PolicyDRF:
+		now := time.Now()
+		shares := make(map[*Queue]float64, len(children))
+		for _, child := range children {
+			if policy == PolicyFair {
+				shares[child] = fairShareRatio(child, clusterTotal)
+			} else {
+				shares[child] = dominantShare(child, clusterTotal)
+			}
+			// A child whose pods wait long enough is served even above its share
+			if since, ok := oldestWaitingSince(child); ok && agingRate > 0 {
+				shares[child] -= float64(agingRate) / 100 * now.Sub(since).Minutes()
+			}
+		}
+		// Equal shares go to the child holding the oldest pending pod
+		sort.SliceStable(children, func(i, j int) bool {
+			a, b := children[i], children[j]
+			if shares[a] != shares[b] {
+				return shares[a] < shares[b]
+			}
+			return lessQueuedPod(PolicyFIFO, 0, bestPendingPod(a, PolicyFIFO, 0), bestPendingPod(b, PolicyFIFO, 0))
+		})
+	case PolicyWRR:
+		// The child smooth weighted round-robin would pick comes first
+		sort.SliceStable(children, func(i, j int) bool {
+			a, b := children[i], children[j]
+			wa, wb := a.wrrCurrentWeight+getQueueWeight(a), b.wrrCurrentWeight+getQueueWeight(b)
+			if wa != wb {
+				return wa > wb
+			}
+			return lessQueuedPod(PolicyFIFO, 0, bestPendingPod(a, PolicyFIFO, 0), bestPendingPod(b, PolicyFIFO, 0))
+		})
+	default:
+		// fifo and priority serve the child holding the pod they would pick first
+		sort.SliceStable(children, func(i, j int) bool {
+			return lessQueuedPod(policy, agingRate, bestPendingPod(children[i], policy, agingRate), bestPendingPod(children[j], policy, agingRate))
+		})
+	}
+}
+
+// Helper to get the round-robin weight of a queue
+func getQueueWeight(q *Queue) int {
+	if q.Config.Weight <= 0 {
+		return 1
+	}
+	return q.Config.Weight
+}
+
+// Helper to advance smooth weighted round-robin after picked was served: every
+// candidate gains its weight and picked gives up the total, so over a cycle each
+// child is served in proportion to its weight without long runs of one child
+func advanceWRR(candidates []*Queue, picked *Queue) {
+	total := 0
+	for _, child := range candidates {
+		child.wrrCurrentWeight += getQueueWeight(child)
+		total += getQueueWeight(child)
+	}
+	picked.wrrCurrentWeight -= total
+}
+
+// Helper to reset the round-robin state of the queue and every queue below it
+func resetWRR(q *Queue) {
+	q.wrrCurrentWeight = 0
+	for _, child := range q.Children {
+		resetWRR(child)
+	}
+}
+
+// Helper to check whether the queue or any queue below it has pending pods
+func hasPendingPods(q *Queue) bool {
+	if q.Pods.Len() > 0 {
+		return true
+	}
+	for _, child := range q.Children {
+		if hasPendingPods(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to find the pending pod in the queue's subtree that policy would pick first
+func bestPendingPod(q *Queue, policy string, agingRate int) *queuedPod {
+	best := q.Pods.peekItem()
+	for _, child := range q.Children {
+		candidate := bestPendingPod(child, policy, agingRate)
+		if candidate != nil && (best == nil || lessQueuedPod(policy, agingRate, candidate, best)) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// Helper to find when the longest waiting pod in the queue's subtree started waiting
+func oldestWaitingSince(q *Queue) (time.Time, bool) {
+	oldest, found := q.Pods.OldestWaitingSince()
+	for _, child := range q.Children {
+		if since, ok := oldestWaitingSince(child); ok && (!found || since.Before(oldest)) {
+			oldest, found = since, true
+		}
+	}
+	return oldest, found
+}
+
+// Helper to sum the resource usage of a queue and every queue below it
+func getQueueUsage(q *Queue) v1.ResourceList {
+	usage := addResourceLists(q.reserved, q.ResourceUsage)
+	for _, child := range q.Children {
+		usage = addResourceLists(usage, getQueueUsage(child))
+	}
+	return usage
+}
+
+// Helper to check whether a queue can take more pods: its usage, including the
+// queues below it, is still within its maximum capacity
+func hasHeadroom(q *Queue, clusterTotal v1.ResourceList) bool {
+	return isWithinMaxCapacity(getQueueUsage(q), clusterTotal, q)
+}
+
+// Helper to check that the pod fits its queue and every parent queue. Above
+// its guaranteed Capacity a queue borrows capacity its siblings leave idle, up
+// to its MaxCapacity, which is only there while every parent up to the
+// cluster itself has room left.
+func fitsQueueHierarchy(queue *Queue, podReq, clusterTotal v1.ResourceList) bool {
+	return getBlockingQueue(queue, podReq, clusterTotal) == nil
+}
+
+// Helper to find the lowest queue, from queue up to root, the pod doesn't fit
+func getBlockingQueue(queue *Queue, podReq, clusterTotal v1.ResourceList) *Queue {
+	for q := queue; q != nil; q = q.Parent {
+		if !isWithinMaxCapacity(addResourceLists(getQueueUsage(q), podReq), clusterTotal, q) {
+			return q
+		}
+	}
+	return nil
+}
+
+// Helper to check that the pod could fit its queue and every parent queue if
+// they were idle. A pod above one of their limits can never be scheduled from
+// the queue, however much capacity is released.
+func checkQueueLimits(queue *Queue, podReq, clusterTotal v1.ResourceList) error {
+	for q := queue; q != nil; q = q.Parent {
+		if !isWithinMaxCapacity(podReq, clusterTotal, q) {
+			return fmt.Errorf("pod requests exceed the maximum capacity of queue %s", q.Path)
+		}
+	}
+	return nil
+}
+
+// Helper to compute how much of its guaranteed share a queue uses: the largest
+// fraction of its guarantee it uses of any resource it is guaranteed. A queue
+// without any guarantee is measured by the largest fraction of its parent's
+// guarantee it uses, so it still gets turns and can be aged ahead.
+func fairShareRatio(q *Queue, clusterTotal v1.ResourceList) float64 {
+	usage := getQueueUsage(q)
+	guaranteed := getQueueGuaranteeMilli(q, clusterTotal)
+	var ratio float64
+	hasGuarantee := false
+	for name, value := range guaranteed {
+		if value <= 0 {
+			continue
+		}
+		hasGuarantee = true
+		used := usage[name]
+		if r := float64(used.MilliValue()) / value; r > ratio {
+			ratio = r
+		}
+	}
+	if hasGuarantee || q.Parent == nil {
+		return ratio
+	}
+	for name, value := range getQueueGuaranteeMilli(q.Parent, clusterTotal) {
+		if value <= 0 {
+			continue
+		}
+		used := usage[name]
+		if r := float64(used.MilliValue()) / value; r > ratio {
+			ratio = r
+		}
+	}
+	return ratio
+}
+
+// Helper to compute the dominant share of a queue for Dominant Resource
+// Fairness: the largest fraction of the cluster total it uses of any resource
+func dominantShare(q *Queue, clusterTotal v1.ResourceList) float64 {
+	var share float64
+	for name, used := range getQueueUsage(q) {
+		total, ok := clusterTotal[name]
+		if !ok || total.IsZero() {
+			continue
+		}
+		if s := float64(used.MilliValue()) / float64(total.MilliValue()); s > share {
+			share = s
+		}
+	}
+	return share
+}
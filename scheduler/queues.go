@@ -3,29 +3,67 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"math"
 	"strings"
+	"time"
+
+	"sample-k8-scheduler/scheduler/update_status"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 type QueueConfig struct {
-	Capacity    int    // Percentage of total cluster resources
-	MaxCapacity int    // Maximum capacity the queue can grow to
-	Policy      string // Scheduling policy (e.g., "fifo", "fair")
+	Capacity        float64 // Percentage of the parent's resources, may be fractional
+	MaxCapacity     float64 // Maximum capacity the queue can grow to
+	Policy          string  // Scheduling policy (e.g., "fifo", "priority")
+	ScoringStrategy string  // Node scoring strategy (e.g., "LeastAllocated", "MostAllocated")
+	Weight          int     // Share of turns among siblings under a "wrr" parent, defaults to 1
+	// Capacity and MaxCapacity for single resources, such as 30% of cpu but 60% of memory
+	CapacityPercents    map[v1.ResourceName]float64
+	MaxCapacityPercents map[v1.ResourceName]float64
+	// Absolute guaranteed and maximum amounts per resource, such as cpu: 40 or
+	// memory: 128Gi, in place of the percentages
+	Guaranteed v1.ResourceList
+	Max        v1.ResourceList
+	// Points per minute of waiting added to a pod's priority under the
+	// "priority" policy, or taken off a child's share in percent under "fair" and "drf"
+	AgingRate int
+	// Number of pods behind a head pod that exceeds capacity that may be
+	// scheduled ahead of it, 0 disables skipping ahead
+	Lookahead int
+	// How long the head pod may be bypassed, DefaultMaxHeadBypass when 0
+	MaxHeadBypassSeconds int
+	// Grace period given to this queue's pods evicted to reclaim borrowed
+	// capacity, 0 uses each pod's own terminationGracePeriodSeconds
+	ReclaimGracePeriodSeconds int
 }
 
+// DefaultMaxHeadBypass bounds skipping ahead when a queue doesn't set MaxHeadBypassSeconds
+const DefaultMaxHeadBypass = 5 * time.Minute
+
 type Queue struct {
 	Name     string
 	Parent   *Queue
 	Children map[string]*Queue
 	Config   QueueConfig
-	Pods     []*v1.Pod
-	Path     string // Full path of queue (e.g., "root.development.team-a")
+	Pods     *PodQueue // Pending pods, ordered by Config.Policy
+	Path     string    // Full path of queue (e.g., "root.development.team-a")
 	// Track current resource usage for the queue
 	ResourceUsage v1.ResourceList
+	// Smooth weighted round-robin state among siblings, reset every scheduling cycle
+	wrrCurrentWeight int
+	// Head pod that exceeded capacity and since when pods have skipped ahead of it
+	headBlockedPod   string
+	headBlockedSince time.Time
+	// Capacity reclaimed for a head pod, counted as used by the queue until
+	// that pod binds so the queues it was taken from can't fill it again
+	reservedFor string
+	reserved    v1.ResourceList
 }
 
 var (
@@ -36,8 +74,9 @@ var (
 		Config: QueueConfig{
 			Capacity:    100,
 			MaxCapacity: 100,
-			Policy:      "fifo",
+			Policy:      PolicyFIFO,
 		},
+		Pods: newPodQueue(PolicyFIFO, 0),
 	}
 	queues = map[string]*Queue{
 		"root": rootQueue,
@@ -46,7 +85,7 @@ var (
 
 // CreateQueue creates a new queue at the specified path
 func CreateQueue(name string, path string, config QueueConfig) error {
-	if path == "" || path == "root" {
+	if path == "" || path == "root" || !strings.HasPrefix(path, "root.") {
 		return fmt.Errorf("invalid queue path")
 	}
 
@@ -61,11 +100,12 @@ func CreateQueue(name string, path string, config QueueConfig) error {
 		child, exists := current.Children[parts[i]]
 		if !exists {
 			child = &Queue{
-				Name:     name,
-				Parent:   current,
-				Path:     strings.Join(parts[:i+1], "."),
-				Children: make(map[string]*Queue),
-				Config:   config,
+				Name:          name,
+				Parent:        current,
+				Path:          strings.Join(parts[:i+1], "."),
+				Children:      make(map[string]*Queue),
+				Config:        config,
+				Pods:          newPodQueue(config.Policy, config.AgingRate),
 				ResourceUsage: v1.ResourceList{},
 			}
 			current.Children[parts[i]] = child
@@ -98,61 +138,220 @@ func GetQueue(path string) *Queue {
 	return current
 }
 
-func Enqueue(pod *v1.Pod) {
-	// Get queue path from pod annotation, default to namespace if not specified
+// Helper to get the queue path of a pod from its annotation, defaulting to its namespace
+func getQueuePathForPod(pod *v1.Pod) string {
 	queuePath := pod.Annotations["scheduler.kubernetes.io/queue"]
 	if queuePath == "" {
-		queuePath = fmt.Sprintf("root.%s", pod.Namespace)
+		return fmt.Sprintf("root.%s", pod.Namespace)
 	}
+	normalized, err := normalizeQueuePath(queuePath)
+	if err != nil {
+		fmt.Printf("Pod %s/%s: %v, using its namespace queue\n", pod.Namespace, pod.Name, err)
+		return fmt.Sprintf("root.%s", pod.Namespace)
+	}
+	return normalized
+}
 
-	queue := GetQueue(queuePath)
+// Helper to turn a queue path into its full form below root, so "team-a" and
+// "root.team-a" name the same queue. Root itself and paths with empty parts
+// are rejected, pods and CRDs can only name queues below root.
+func normalizeQueuePath(path string) (string, error) {
+	if path == "root" {
+		return "", fmt.Errorf("invalid queue path %q", path)
+	}
+	if !strings.HasPrefix(path, "root.") {
+		path = "root." + path
+	}
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			return "", fmt.Errorf("invalid queue path %q", path)
+		}
+	}
+	return path, nil
+}
+
+func Enqueue(pod *v1.Pod) {
+	queue := getOrCreatePodQueue(pod)
 	if queue == nil {
-		// Create default queue for namespace if it doesn't exist
-		err := CreateQueue("", queuePath, QueueConfig{
-			Capacity:    0, // No specific capacity limit
-			MaxCapacity: 100,
-			Policy:      "fifo",
-		})
-		if err != nil {
-			return
+		return
+	}
+	// Pods listed again while still pending are only queued once
+	queue.Pods.Add(pod)
+}
+
+// Helper to get the queue of a pod, creating a default queue for its
+// namespace if it doesn't exist
+func getOrCreatePodQueue(pod *v1.Pod) *Queue {
+	queuePath := getQueuePathForPod(pod)
+	if queue := GetQueue(queuePath); queue != nil {
+		return queue
+	}
+	err := CreateQueue("", queuePath, QueueConfig{
+		Capacity:    0, // No specific capacity limit
+		MaxCapacity: 100,
+		Policy:      PolicyFIFO,
+	})
+	if err != nil {
+		return nil
+	}
+	return GetQueue(queuePath)
+}
+
+// Helper to list the pods we placed that still hold their requests: bound,
+// not finished and not being deleted. Pods being deleted, for example after
+// an eviction, are on their way out and no longer count.
+func getBoundPods(clientset kubernetes.Interface) ([]*v1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	bound := make([]*v1.Pod, 0, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		if !hasProfile(pod.Spec.SchedulerName) {
+			continue
+		}
+		bound = append(bound, pod)
+	}
+	return bound, nil
+}
+
+// Helper to recompute the resource usage of every queue from the bound pods,
+// so pods that completed, were deleted or were evicted give their capacity
+// back, and usage is right again after a scheduler restart. The Queue CRD
+// status of every queue whose usage changed is rewritten.
+func refreshQueueUsage(config *rest.Config, pods []*v1.Pod, clusterTotal v1.ResourceList) {
+	previous := map[*Queue]v1.ResourceList{}
+	resetQueueUsage(rootQueue, previous)
+	for _, pod := range pods {
+		queue := getOrCreatePodQueue(pod)
+		if queue == nil {
+			continue
+		}
+		queue.ResourceUsage = addResourceLists(queue.ResourceUsage, getPodResourceRequests(pod))
+	}
+	for _, q := range queues {
+		if equalResourceLists(previous[q], q.ResourceUsage) {
+			continue
+		}
+		if err := update_status.UpdateQueueStatus(config, q.Name, buildQueueStatus(q, clusterTotal)); err != nil {
+			fmt.Printf("Failed to update status of queue %s: %v\n", q.Path, err)
 		}
-		queue = GetQueue(queuePath)
 	}
+}
 
-	queue.Pods = append(queue.Pods, pod)
+// Helper to clear the usage of the queue and every queue below it,
+// keeping the old usage in previous
+func resetQueueUsage(q *Queue, previous map[*Queue]v1.ResourceList) {
+	previous[q] = q.ResourceUsage
+	q.ResourceUsage = v1.ResourceList{}
+	for _, child := range q.Children {
+		resetQueueUsage(child, previous)
+	}
 }
 
+// Helper to check whether two resource lists hold the same quantities,
+// treating missing resources as zero
+func equalResourceLists(a, b v1.ResourceList) bool {
+	for name, quantity := range a {
+		other := b[name]
+		if quantity.Cmp(other) != 0 {
+			return false
+		}
+	}
+	for name, quantity := range b {
+		other := a[name]
+		if quantity.Cmp(other) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Dequeue removes and returns the next pod of the queue according to its policy
 func Dequeue(queuePath string) *v1.Pod {
 	queue := GetQueue(queuePath)
-	if queue == nil || len(queue.Pods) == 0 {
+	if queue == nil {
 		return nil
 	}
-
-	// Apply queue policy (currently only FIFO)
-	pod := queue.Pods[0]
-	queue.Pods = queue.Pods[1:]
-	return pod
+	return queue.Pods.PopPod()
 }
 
-// Helper to sum resource requests for a pod
+// Helper to compute the effective resource requests of a pod, using the same
+// formula as upstream:
+//   - app containers and restartable (sidecar) init containers are summed
+//   - each regular init container runs alone next to the sidecars started
+//     before it, so the pod needs at least the largest of those
+//   - pod-level requests (spec.resources) replace the container totals for
+//     the resources they set
+//   - spec.overhead from the RuntimeClass is added on top
 func getPodResourceRequests(pod *v1.Pod) v1.ResourceList {
 	total := v1.ResourceList{}
 	for _, c := range pod.Spec.Containers {
-		for name, quantity := range c.Resources.Requests {
-			if val, ok := total[name]; ok {
-				val.Add(quantity)
-				total[name] = val
-			} else {
+		total = addResourceLists(total, c.Resources.Requests)
+	}
+
+	restartableInitReqs := v1.ResourceList{}
+	initReqs := v1.ResourceList{}
+	for _, c := range pod.Spec.InitContainers {
+		containerReqs := c.Resources.Requests
+		if c.RestartPolicy != nil && *c.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			// Sidecars keep running alongside the app containers
+			total = addResourceLists(total, containerReqs)
+			restartableInitReqs = addResourceLists(restartableInitReqs, containerReqs)
+			containerReqs = restartableInitReqs
+		} else {
+			containerReqs = addResourceLists(containerReqs, restartableInitReqs)
+		}
+		initReqs = maxResourceLists(initReqs, containerReqs)
+	}
+	total = maxResourceLists(total, initReqs)
+
+	if pod.Spec.Resources != nil {
+		for name, quantity := range pod.Spec.Resources.Requests {
+			if isSupportedPodLevelResource(name) {
 				total[name] = quantity.DeepCopy()
 			}
 		}
 	}
+
+	if pod.Spec.Overhead != nil {
+		total = addResourceLists(total, pod.Spec.Overhead)
+	}
 	return total
 }
 
+// Helper to check whether a resource can be requested at pod level
+func isSupportedPodLevelResource(name v1.ResourceName) bool {
+	return name == v1.ResourceCPU || name == v1.ResourceMemory
+}
+
+// Helper to take the per-resource maximum of two resource lists
+func maxResourceLists(a, b v1.ResourceList) v1.ResourceList {
+	result := a.DeepCopy()
+	if result == nil {
+		result = v1.ResourceList{}
+	}
+	for name, quantity := range b {
+		if val, ok := result[name]; !ok || quantity.Cmp(val) > 0 {
+			result[name] = quantity.DeepCopy()
+		}
+	}
+	return result
+}
+
 // Helper to sum two resource lists
 func addResourceLists(a, b v1.ResourceList) v1.ResourceList {
 	result := a.DeepCopy()
+	if result == nil {
+		result = v1.ResourceList{}
+	}
 	for name, quantity := range b {
 		if val, ok := result[name]; ok {
 			val.Add(quantity)
@@ -164,36 +363,219 @@ func addResourceLists(a, b v1.ResourceList) v1.ResourceList {
 	return result
 }
 
-// Helper to compute effective capacity percentage for a queue (relative to root)
-func getEffectiveCapacityPercent(q *Queue) int {
-	percent := q.Config.Capacity
-	parent := q.Parent
-	for parent != nil {
-		percent = percent * parent.Config.Capacity / 100
-		parent = parent.Parent
+// Helper to subtract b from a, leaving no resource below zero
+func subtractResourceLists(a, b v1.ResourceList) v1.ResourceList {
+	result := a.DeepCopy()
+	if result == nil {
+		result = v1.ResourceList{}
 	}
-	return percent
+	for name, quantity := range b {
+		if val, ok := result[name]; ok {
+			val.Sub(quantity)
+			if val.Sign() < 0 {
+				val = *resource.NewQuantity(0, val.Format)
+			}
+			result[name] = val
+		}
+	}
+	return result
+}
+
+// Helper to get the resources guaranteed to a queue. Resources set in
+// Config.Guaranteed are absolute, the others get their capacity percentage of
+// what the parent is guaranteed. Fractions are kept down the hierarchy, except that
+// extended resources such as GPUs are only handed out in whole devices.
+func getQueueGuarantee(q *Queue, total v1.ResourceList) v1.ResourceList {
+	return roundQueueResources(getQueueGuaranteeMilli(q, total), total)
 }
 
-// Helper to compare resource usage with effective capacity
+// Helper to get the most resources a queue may use, borrowing included.
+// Resources set in Config.Max are absolute, the others get their maximum
+// capacity percentage of the parent's limit. A queue without MaxCapacity can't grow past its
+// guarantee.
+func getQueueLimit(q *Queue, total v1.ResourceList) v1.ResourceList {
+	return roundQueueResources(getQueueLimitMilli(q, total), total)
+}
+
+func getQueueGuaranteeMilli(q *Queue, total v1.ResourceList) map[v1.ResourceName]float64 {
+	parent := getMilliValues(total)
+	if q.Parent != nil {
+		parent = getQueueGuaranteeMilli(q.Parent, total)
+	}
+	result := make(map[v1.ResourceName]float64, len(parent))
+	for name, value := range parent {
+		result[name] = value * getCapacityPercent(q, name) / 100
+	}
+	for name, quantity := range q.Config.Guaranteed {
+		result[name] = float64(quantity.MilliValue())
+	}
+	return result
+}
+
+func getQueueLimitMilli(q *Queue, total v1.ResourceList) map[v1.ResourceName]float64 {
+	parent := getMilliValues(total)
+	if q.Parent != nil {
+		parent = getQueueLimitMilli(q.Parent, total)
+	}
+	result := make(map[v1.ResourceName]float64, len(parent))
+	for name, value := range parent {
+		_, absolute := q.Config.Guaranteed[name]
+		_, maxPercent := q.Config.MaxCapacityPercents[name]
+		if absolute && q.Config.MaxCapacity == 0 && !maxPercent {
+			// An absolute guarantee without a maximum capacity is also the limit
+			continue
+		}
+		result[name] = value * getMaxCapacityPercent(q, name) / 100
+	}
+	for name, quantity := range q.Config.Max {
+		result[name] = float64(quantity.MilliValue())
+	}
+	for name, value := range getQueueGuaranteeMilli(q, total) {
+		if value > result[name] {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// Helper to get the capacity percentage of a queue for one resource
+func getCapacityPercent(q *Queue, name v1.ResourceName) float64 {
+	if percent, ok := q.Config.CapacityPercents[name]; ok {
+		return percent
+	}
+	return q.Config.Capacity
+}
+
+// Helper to get the maximum capacity percentage of a queue for one resource,
+// which is never below its capacity
+func getMaxCapacityPercent(q *Queue, name v1.ResourceName) float64 {
+	percent := q.Config.MaxCapacity
+	if p, ok := q.Config.MaxCapacityPercents[name]; ok {
+		percent = p
+	}
+	return math.Max(percent, getCapacityPercent(q, name))
+}
+
+// Helper to get the milli value of every resource
+func getMilliValues(resources v1.ResourceList) map[v1.ResourceName]float64 {
+	result := make(map[v1.ResourceName]float64, len(resources))
+	for name, quantity := range resources {
+		result[name] = float64(quantity.MilliValue())
+	}
+	return result
+}
+
+// Helper to turn milli values back into quantities, formatted like the cluster
+// total. Extended resources are rounded down to whole devices.
+func roundQueueResources(milli map[v1.ResourceName]float64, total v1.ResourceList) v1.ResourceList {
+	result := make(v1.ResourceList, len(milli))
+	for name, value := range milli {
+		if isExtendedResourceName(name) {
+			result[name] = *resource.NewQuantity(int64(math.Floor(value/1000)), resource.DecimalSI)
+			continue
+		}
+		format := resource.DecimalSI
+		if totalQty, ok := total[name]; ok {
+			format = totalQty.Format
+		}
+		result[name] = *resource.NewMilliQuantity(int64(value), format)
+	}
+	return result
+}
+
+// Helper to compare resource usage with effective capacity. Resources the
+// cluster does not have leave no room at all, and extended resources such as
+// GPUs are only handed out in whole devices.
 func isWithinCapacity(usage, total v1.ResourceList, queue *Queue) bool {
-	effectivePercent := getEffectiveCapacityPercent(queue)
-	for name, totalQty := range total {
-		capQty := totalQty.DeepCopy()
-		capVal := int64(float64(capQty.MilliValue()) * float64(effectivePercent) / 100.0)
-		usageQty, ok := usage[name]
-		if !ok {
+	return isWithinResources(usage, getQueueGuarantee(queue, total))
+}
+
+// Helper to compare resource usage with the maximum capacity of a queue
+func isWithinMaxCapacity(usage, total v1.ResourceList, queue *Queue) bool {
+	return isWithinResources(usage, getQueueLimit(queue, total))
+}
+
+// Helper to check that no resource in usage exceeds its amount in capacity
+func isWithinResources(usage, capacity v1.ResourceList) bool {
+	for name, usageQty := range usage {
+		if usageQty.IsZero() {
+			continue
+		}
+		capQty := capacity[name]
+		if isExtendedResourceName(name) {
+			fmt.Printf("Checking %s: usage=%d, capacity=%d\n", name, usageQty.Value(), capQty.Value())
+			if usageQty.Value() > capQty.Value() {
+				return false
+			}
 			continue
 		}
 		// print usageQty and capVal for debugging
-		fmt.Printf("Checking %s: usage=%d, capacity=%d\n", name, usageQty.MilliValue(), capVal)
-		if usageQty.MilliValue() > capVal {
+		fmt.Printf("Checking %s: usage=%d, capacity=%d\n", name, usageQty.MilliValue(), capQty.MilliValue())
+		if usageQty.MilliValue() > capQty.MilliValue() {
 			return false
 		}
 	}
 	return true
 }
 
+// Helper to compute how much of the usage lies above the guarantee, per resource
+func getBorrowedResources(usage, guarantee v1.ResourceList) v1.ResourceList {
+	borrowed := v1.ResourceList{}
+	for name, usageQty := range usage {
+		over := usageQty.DeepCopy()
+		over.Sub(guarantee[name])
+		if over.Sign() > 0 {
+			borrowed[name] = over
+		}
+	}
+	return borrowed
+}
+
+// Helper to check whether a resource is an extended resource such as
+// nvidia.com/gpu, i.e. a domain-prefixed name outside kubernetes.io
+func isExtendedResourceName(name v1.ResourceName) bool {
+	s := string(name)
+	if !strings.Contains(s, "/") || strings.Contains(s, "kubernetes.io/") {
+		return false
+	}
+	return !strings.HasPrefix(s, v1.DefaultResourceRequestsPrefix)
+}
+
+// Helper to reject pods that request fractions of an extended resource or
+// set requests different from limits for one, since devices cannot be shared
+// or overcommitted
+func validateExtendedResources(pod *v1.Pod) error {
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		for name, quantity := range c.Resources.Requests {
+			if !isExtendedResourceName(name) {
+				continue
+			}
+			if quantity.MilliValue()%1000 != 0 {
+				return fmt.Errorf("container %s requests non-integer quantity %s of extended resource %s", c.Name, quantity.String(), name)
+			}
+			if limit, ok := c.Resources.Limits[name]; ok && limit.Cmp(quantity) != 0 {
+				return fmt.Errorf("container %s must set equal request and limit for extended resource %s", c.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Helper to compute the usage of each resource as a whole percentage of the cluster total
+func getUsagePercents(usage, total v1.ResourceList) map[v1.ResourceName]int {
+	percents := map[v1.ResourceName]int{}
+	for name, usageQty := range usage {
+		totalQty, ok := total[name]
+		if !ok || totalQty.IsZero() {
+			percents[name] = 0
+			continue
+		}
+		percents[name] = int(float64(usageQty.MilliValue()) / float64(totalQty.MilliValue()) * 100)
+	}
+	return percents
+}
+
 // Calculate total cluster resources (sum of all node allocatable)
 func GetClusterTotalResources(clientset kubernetes.Interface) (v1.ResourceList, error) {
 	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
@@ -223,23 +605,61 @@ func UpdateQueueState(obj interface{}) {
 	}
 	name := u.GetName()
 	path, _, _ := unstructured.NestedString(u.Object, "spec", "path")
-	capacity, _, _ := unstructured.NestedInt64(u.Object, "spec", "capacity")
-	maxCapacity, _, _ := unstructured.NestedInt64(u.Object, "spec", "maxCapacity")
+	capacity := getNestedNumber(u.Object, "spec", "capacity")
+	maxCapacity := getNestedNumber(u.Object, "spec", "maxCapacity")
+	capacityPercents := getNestedPercents(u.Object, "spec", "resources", "capacity")
+	maxCapacityPercents := getNestedPercents(u.Object, "spec", "resources", "maxCapacity")
+	guaranteed := getNestedResourceList(u.Object, "spec", "resources", "guaranteed")
+	maxResources := getNestedResourceList(u.Object, "spec", "resources", "max")
 	policy, _, _ := unstructured.NestedString(u.Object, "spec", "policy")
+	scoringStrategy, _, _ := unstructured.NestedString(u.Object, "spec", "scoringStrategy")
+	weight, _, _ := unstructured.NestedInt64(u.Object, "spec", "weight")
+	agingRate, _, _ := unstructured.NestedInt64(u.Object, "spec", "agingRate")
+	lookahead, _, _ := unstructured.NestedInt64(u.Object, "spec", "lookahead")
+	maxHeadBypassSeconds, _, _ := unstructured.NestedInt64(u.Object, "spec", "maxHeadBypassSeconds")
+	reclaimGracePeriodSeconds, _, _ := unstructured.NestedInt64(u.Object, "spec", "reclaimGracePeriodSeconds")
 
 	if path == "" {
 		path = fmt.Sprintf("root.%s", name)
 	}
+	path, err := normalizeQueuePath(path)
+	if err != nil {
+		fmt.Printf("Ignoring queue %s: %v\n", name, err)
+		return
+	}
+	if scoringStrategy != "" && !isValidScoringStrategy(scoringStrategy) {
+		fmt.Printf("Unknown scoring strategy %q for queue %s, using %s\n", scoringStrategy, path, LeastAllocated)
+		scoringStrategy = ""
+	}
+	if policy != "" && !isValidPolicy(policy) {
+		fmt.Printf("Unknown policy %q for queue %s, using %s\n", policy, path, PolicyFIFO)
+		policy = ""
+	}
+	if policy == "" {
+		policy = PolicyFIFO
+	}
 	config := QueueConfig{
-		Capacity:    int(capacity),
-		MaxCapacity: int(maxCapacity),
-		Policy:      policy,
+		Capacity:                  capacity,
+		MaxCapacity:               maxCapacity,
+		CapacityPercents:          capacityPercents,
+		MaxCapacityPercents:       maxCapacityPercents,
+		Guaranteed:                guaranteed,
+		Max:                       maxResources,
+		Policy:                    policy,
+		ScoringStrategy:           scoringStrategy,
+		Weight:                    int(weight),
+		AgingRate:                 int(agingRate),
+		Lookahead:                 int(lookahead),
+		MaxHeadBypassSeconds:      int(maxHeadBypassSeconds),
+		ReclaimGracePeriodSeconds: int(reclaimGracePeriodSeconds),
 	}
 
 	q := GetQueue(path)
 	if q != nil {
 		// Update config only, keep pods and resource usage
 		q.Config = config
+		q.Pods.SetPolicy(policy)
+		q.Pods.SetAgingRate(config.AgingRate)
 		fmt.Printf("Queue config updated: %s\n", path)
 	} else {
 		// Create new queue
@@ -253,6 +673,68 @@ func UpdateQueueState(obj interface{}) {
 	queues[path] = GetQueue(path)
 }
 
+// Helper to read a number from the CRD object, which holds whole numbers as
+// int64 and fractions as float64
+func getNestedNumber(obj map[string]interface{}, fields ...string) float64 {
+	value, found, err := unstructured.NestedFieldNoCopy(obj, fields...)
+	if !found || err != nil {
+		return 0
+	}
+	number, ok := toFloat64(value)
+	if !ok {
+		fmt.Printf("Ignoring non-numeric %s: %v\n", strings.Join(fields, "."), value)
+	}
+	return number
+}
+
+// Helper to read a map of percentages per resource from the CRD object
+func getNestedPercents(obj map[string]interface{}, fields ...string) map[v1.ResourceName]float64 {
+	values, found, err := unstructured.NestedMap(obj, fields...)
+	if !found || err != nil {
+		return nil
+	}
+	result := map[v1.ResourceName]float64{}
+	for name, value := range values {
+		percent, ok := toFloat64(value)
+		if !ok {
+			fmt.Printf("Ignoring non-numeric percentage %v of %s in %s\n", value, name, strings.Join(fields, "."))
+			continue
+		}
+		result[v1.ResourceName(name)] = percent
+	}
+	return result
+}
+
+// Helper to convert a number decoded from JSON to float64
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Helper to read a map of resource quantities from the CRD object. Values may
+// be numbers or quantity strings; invalid ones are logged and ignored.
+func getNestedResourceList(obj map[string]interface{}, fields ...string) v1.ResourceList {
+	values, found, err := unstructured.NestedMap(obj, fields...)
+	if !found || err != nil {
+		return nil
+	}
+	result := v1.ResourceList{}
+	for name, value := range values {
+		quantity, err := resource.ParseQuantity(fmt.Sprint(value))
+		if err != nil {
+			fmt.Printf("Ignoring invalid quantity %v of %s in %s: %v\n", value, name, strings.Join(fields, "."), err)
+			continue
+		}
+		result[v1.ResourceName(name)] = quantity
+	}
+	return result
+}
+
 // DeleteQueueState removes the queue state for a deleted CRD object
 func DeleteQueueState(obj interface{}) {
 	u, ok := obj.(*unstructured.Unstructured)
@@ -264,6 +746,11 @@ func DeleteQueueState(obj interface{}) {
 	if path == "" {
 		path = fmt.Sprintf("root.%s", u.GetName())
 	}
+	path, err := normalizeQueuePath(path)
+	if err != nil {
+		fmt.Printf("Ignoring queue %s: %v\n", u.GetName(), err)
+		return
+	}
 	delete(queues, path)
 	fmt.Printf("Queue deleted: %s\n", path)
 }
@@ -0,0 +1,211 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"sample-k8-scheduler/scheduler/extender"
+	"sample-k8-scheduler/scheduler/update_status"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// reclaimCandidate is a running pod of a queue that uses capacity above its guarantee
+type reclaimCandidate struct {
+	pod   *v1.Pod
+	queue *Queue
+}
+
+// Helper to take borrowed capacity back for a queue whose head pod fits its
+// guarantee but not the hierarchy, because other queues borrowed what it was
+// promised. Victims are picked by selectReclaimVictims and nothing is evicted
+// unless they make the head fit. It returns true when the head fits now; it
+// starts once the evicted pods have terminated.
+func reclaimCapacity(clientset kubernetes.Interface, config *rest.Config, queue *Queue, head *v1.Pod, podReq, clusterTotal v1.ResourceList) bool {
+	if !isWithinCapacity(addResourceLists(getQueueUsage(queue), podReq), clusterTotal, queue) {
+		// The queue would borrow itself, there is nothing to reclaim
+		return false
+	}
+	pods, err := getBoundPods(clientset)
+	if err != nil {
+		fmt.Printf("Error listing pods to reclaim capacity for queue %s: %v\n", queue.Path, err)
+		return false
+	}
+	candidates := getReclaimCandidates(pods, queue, clusterTotal)
+	approved, err := approveReclaimVictims(head, candidates)
+	if err != nil {
+		fmt.Printf("Extender rejected reclaiming capacity for pod %s: %v\n", head.Name, err)
+		return false
+	}
+	victims := selectReclaimVictims(queue, podReq, clusterTotal, candidates, approved)
+	if victims == nil {
+		fmt.Printf("Not enough borrowed capacity to reclaim for pod %s in queue %s\n", head.Name, queue.Path)
+		return false
+	}
+	for _, c := range victims {
+		if err := evictPod(clientset, c.pod, c.queue.Config.ReclaimGracePeriodSeconds); err != nil {
+			fmt.Printf("Failed to evict pod %s/%s: %v\n", c.pod.Namespace, c.pod.Name, err)
+			// The pod keeps running, so its queue keeps the usage
+			c.queue.ResourceUsage = addResourceLists(c.queue.ResourceUsage, getPodResourceRequests(c.pod))
+			continue
+		}
+		fmt.Printf("Evicted pod %s/%s of queue %s to reclaim capacity for queue %s\n", c.pod.Namespace, c.pod.Name, c.queue.Path, queue.Path)
+		message := fmt.Sprintf("Evicted to reclaim capacity borrowed by queue %s for queue %s", c.queue.Path, queue.Path)
+		if err := recordPodEvent(clientset, c.pod, v1.EventTypeWarning, "Preempted", message); err != nil {
+			fmt.Printf("Failed to record event for pod %s: %v\n", c.pod.Name, err)
+		}
+		if err := update_status.UpdateQueueStatus(config, c.queue.Name, buildQueueStatus(c.queue, clusterTotal)); err != nil {
+			fmt.Printf("Failed to update queue status: %v\n", err)
+		}
+	}
+	return fitsQueueHierarchy(queue, podReq, clusterTotal)
+}
+
+// Helper to pick the pods to evict so the head pod fits. At the lowest queue
+// the pod doesn't fit, the most recently started pod of a queue below it that
+// borrows a resource that queue is short of is taken, until the pod fits every
+// level. The usage of the victims' queues is lowered as they are picked; when
+// the pod can't be made to fit, usage is restored and nil is returned.
+func selectReclaimVictims(queue *Queue, podReq, clusterTotal v1.ResourceList, candidates []reclaimCandidate, approved map[string]bool) []reclaimCandidate {
+	saved := map[*Queue]v1.ResourceList{}
+	picked := map[string]bool{}
+	victims := []reclaimCandidate{}
+	for {
+		blocking := getBlockingQueue(queue, podReq, clusterTotal)
+		if blocking == nil {
+			return victims
+		}
+		short := getBorrowedResources(addResourceLists(getQueueUsage(blocking), podReq), getQueueLimit(blocking, clusterTotal))
+		var victim *reclaimCandidate
+		for i := range candidates {
+			c := &candidates[i]
+			if picked[podKey(c.pod)] || !approved[podKey(c.pod)] || !isQueueBelow(c.queue, blocking) {
+				continue
+			}
+			if freesBorrowedResources(*c, short, clusterTotal) {
+				victim = c
+				break
+			}
+		}
+		if victim == nil {
+			for q, usage := range saved {
+				q.ResourceUsage = usage
+			}
+			return nil
+		}
+		if _, ok := saved[victim.queue]; !ok {
+			saved[victim.queue] = victim.queue.ResourceUsage
+		}
+		victim.queue.ResourceUsage = subtractResourceLists(victim.queue.ResourceUsage, getPodResourceRequests(victim.pod))
+		picked[podKey(victim.pod)] = true
+		victims = append(victims, *victim)
+	}
+}
+
+// Helper to check whether q is ancestor or one of the queues below it
+func isQueueBelow(q, ancestor *Queue) bool {
+	for current := q; current != nil; current = current.Parent {
+		if current == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to pick the bound pods of every other queue that is above its
+// guarantee, most recently started first, since those used borrowed capacity
+func getReclaimCandidates(pods []*v1.Pod, queue *Queue, clusterTotal v1.ResourceList) []reclaimCandidate {
+	candidates := []reclaimCandidate{}
+	for _, pod := range pods {
+		owner := GetQueue(getQueuePathForPod(pod))
+		if owner == nil || owner == queue {
+			continue
+		}
+		if len(getBorrowedResources(owner.ResourceUsage, getQueueGuarantee(owner, clusterTotal))) == 0 {
+			continue
+		}
+		candidates = append(candidates, reclaimCandidate{pod: pod, queue: owner})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return podStartTime(candidates[i].pod).After(podStartTime(candidates[j].pod).Time)
+	})
+	return candidates
+}
+
+// Helper to get when a pod started, falling back to its creation
+func podStartTime(pod *v1.Pod) metav1.Time {
+	if pod.Status.StartTime != nil {
+		return *pod.Status.StartTime
+	}
+	return pod.CreationTimestamp
+}
+
+// Helper to check that evicting the candidate gives back borrowed capacity of
+// one of the needed resources. Queues stop losing pods once they are back
+// within their guarantee.
+func freesBorrowedResources(c reclaimCandidate, needed, clusterTotal v1.ResourceList) bool {
+	borrowed := getBorrowedResources(c.queue.ResourceUsage, getQueueGuarantee(c.queue, clusterTotal))
+	victimReq := getPodResourceRequests(c.pod)
+	for name, quantity := range needed {
+		if quantity.IsZero() {
+			continue
+		}
+		if _, ok := borrowed[name]; !ok {
+			continue
+		}
+		if req, ok := victimReq[name]; ok && !req.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to let the extenders of the preempting pod's profile veto victims.
+// It returns the candidates that may be evicted, keyed by namespace/name.
+func approveReclaimVictims(pod *v1.Pod, candidates []reclaimCandidate) (map[string]bool, error) {
+	nodeNameToVictims := map[string]*extender.Victims{}
+	for _, c := range candidates {
+		victims, ok := nodeNameToVictims[c.pod.Spec.NodeName]
+		if !ok {
+			victims = &extender.Victims{}
+			nodeNameToVictims[c.pod.Spec.NodeName] = victims
+		}
+		victims.Pods = append(victims.Pods, c.pod)
+	}
+	fwk, err := frameworkForPod(pod)
+	if err != nil {
+		return nil, err
+	}
+	nodeNameToVictims, err = fwk.RunExtenderPreemption(pod, nodeNameToVictims)
+	if err != nil {
+		return nil, err
+	}
+	approved := map[string]bool{}
+	for _, victims := range nodeNameToVictims {
+		for _, p := range victims.Pods {
+			approved[podKey(p)] = true
+		}
+	}
+	return approved, nil
+}
+
+// Helper to evict a pod through the Eviction API, so PodDisruptionBudgets are
+// respected. A gracePeriodSeconds of 0 keeps the pod's own grace period.
+func evictPod(clientset kubernetes.Interface, pod *v1.Pod, gracePeriodSeconds int) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+	}
+	if gracePeriodSeconds > 0 {
+		grace := int64(gracePeriodSeconds)
+		eviction.DeleteOptions = &metav1.DeleteOptions{GracePeriodSeconds: &grace}
+	}
+	return clientset.PolicyV1().Evictions(pod.Namespace).Evict(context.TODO(), eviction)
+}
@@ -3,6 +3,8 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"os"
 	"time"
 
 	"path/filepath"
@@ -10,6 +12,7 @@ import (
 	"sample-k8-scheduler/scheduler/update_status"
 
 	v1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
@@ -32,18 +35,30 @@ func Start() {
 	if err != nil {
 		panic(err.Error())
 	}
+	if path := os.Getenv(ExtendersConfigEnv); path != "" {
+		if err := LoadExtenders(path); err != nil {
+			panic(err.Error())
+		}
+		fmt.Printf("Loaded extenders from %s\n", path)
+	}
 
 	// Start watching Queue CRD
 	go WatchQueueCRD(config)
+	// Start watching PriorityClasses for the priority policy
+	go WatchPriorityClasses(clientset)
+	// Serve queue metrics such as the longest wait per queue
+	go func() {
+		if err := http.ListenAndServe(MetricsAddr, MetricsHandler()); err != nil {
+			fmt.Printf("Error serving metrics: %v\n", err)
+		}
+	}()
 
 	for {
-		pods, _ := clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{
-			FieldSelector: "spec.schedulerName=kubescheduler,spec.nodeName=",
-		})
-
-		for _, pod := range pods.Items {
-			fmt.Printf("Found pod to schedule: %s/%s\n", pod.Namespace, pod.Name)
-			SchedulePodWithCapacity(clientset, config, &pod)
+		pending, err := listPendingPods(clientset)
+		if err != nil {
+			fmt.Printf("Error listing pending pods: %v\n", err)
+		} else {
+			ScheduleCycle(clientset, config, pending)
 		}
 
 		time.Sleep(2 * time.Second)
@@ -51,6 +66,27 @@ func Start() {
 
 }
 
+// Helper to list the unassigned pods of every profile. Pods are listed by
+// spec.nodeName only, since each profile has its own spec.schedulerName.
+func listPendingPods(clientset kubernetes.Interface) ([]*v1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "spec.nodeName=",
+	})
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]*v1.Pod, 0, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != "" || !hasProfile(pod.Spec.SchedulerName) {
+			continue
+		}
+		fmt.Printf("Found pod to schedule: %s/%s\n", pod.Namespace, pod.Name)
+		pending = append(pending, pod)
+	}
+	return pending, nil
+}
+
 // WatchQueueCRD watches for changes to the Queue CRD and updates scheduler state
 func WatchQueueCRD(config *rest.Config) {
 	dynClient, err := dynamic.NewForConfig(config)
@@ -72,105 +108,270 @@ func WatchQueueCRD(config *rest.Config) {
 	}
 
 	for event := range watcher.ResultChan() {
+		handleQueueEvent(event)
+	}
+}
+
+// Changes made by the watchers, applied on the scheduling goroutine at the
+// start of every cycle so queues and their pod heaps are never changed while
+// a cycle uses them
+var stateUpdates = make(chan func(), 100)
+
+// Helper to apply the changes the watchers queued since the last cycle
+func applyStateUpdates() {
+	for {
+		select {
+		case update := <-stateUpdates:
+			update()
+		default:
+			return
+		}
+	}
+}
+
+// Helper to queue the change of a Queue CRD event for the scheduling goroutine
+func handleQueueEvent(event watch.Event) {
+	obj := event.Object
+	switch event.Type {
+	case watch.Added, watch.Modified:
+		fmt.Printf("Queue CRD event: %v\n", event.Type)
+		stateUpdates <- func() { UpdateQueueState(obj) } // Now calls the function from queues.go
+	case watch.Deleted:
+		fmt.Printf("Queue CRD deleted event\n")
+		stateUpdates <- func() { DeleteQueueState(obj) } // Now calls the function from queues.go
+	}
+}
+
+// WatchPriorityClasses keeps the PriorityClass values used by the priority policy up to date
+func WatchPriorityClasses(clientset kubernetes.Interface) {
+	watcher, err := clientset.SchedulingV1().PriorityClasses().Watch(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		fmt.Printf("Error watching PriorityClasses: %v\n", err)
+		return
+	}
+
+	for event := range watcher.ResultChan() {
+		pc, ok := event.Object.(*schedulingv1.PriorityClass)
+		if !ok {
+			continue
+		}
 		switch event.Type {
 		case watch.Added, watch.Modified:
-			fmt.Printf("Queue CRD event: %v\n", event.Type)
-			UpdateQueueState(event.Object) // Now calls the function from queues.go
+			stateUpdates <- func() { UpdatePriorityClass(pc) }
 		case watch.Deleted:
-			fmt.Printf("Queue CRD deleted event\n")
-			DeleteQueueState(event.Object) // Now calls the function from queues.go
+			stateUpdates <- func() { DeletePriorityClass(pc) }
 		}
 	}
 }
 
-func SchedulePod(clientset kubernetes.Interface, pod *v1.Pod) {
-	Enqueue(pod)
-	selected := Dequeue(pod.Namespace)
-	if selected == nil {
-		return
+// ScheduleCycle applies the watchers' changes, queues the pending pods,
+// recomputes queue usage from the bound pods and schedules pods until every
+// queue is empty or blocked. Each pod is taken from the leaf queue picked by
+// SelectLeafQueue, so one queue with many pods cannot starve its siblings.
+func ScheduleCycle(clientset kubernetes.Interface, config *rest.Config, pods []*v1.Pod) {
+	applyStateUpdates()
+	pending := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		Enqueue(pod)
+		pending[podKey(pod)] = true
 	}
-
-	node, err := SelectBestNode(clientset)
+	// Forget pods that were bound or deleted since the last cycle
+	pruneQueuedPods(rootQueue, pending)
+	resetWRR(rootQueue)
+	recordDeadlineExceeded(clientset, pods, time.Now())
+	clusterTotal, err := GetClusterTotalResources(clientset)
 	if err != nil {
-		fmt.Printf("No suitable node: %v\n", err)
+		fmt.Printf("Error getting cluster resources: %v\n", err)
 		return
 	}
-
-	err = BindPod(clientset, selected, node)
+	bound, err := getBoundPods(clientset)
 	if err != nil {
-		fmt.Printf("Binding failed: %v\n", err)
-	} else {
-		fmt.Printf("Bound pod %s to node %s\n", selected.Name, node)
+		fmt.Printf("Error listing bound pods: %v\n", err)
+		return
+	}
+	refreshQueueUsage(config, bound, clusterTotal)
+	// Queues whose head pod exceeds capacity wait for the next cycle
+	blocked := map[*Queue]bool{}
+	for {
+		queue := SelectLeafQueue(clusterTotal, blocked)
+		if queue == nil {
+			updateQueueMetrics(time.Now())
+			return
+		}
+		if !scheduleQueueHead(clientset, config, queue, clusterTotal) {
+			blocked[queue] = true
+		}
 	}
 }
 
-// SchedulePodWithCapacity enforces queue capacity when scheduling
-func SchedulePodWithCapacity(clientset kubernetes.Interface, config *rest.Config, pod *v1.Pod) {
-	Enqueue(pod)
-	queuePath := pod.Annotations["scheduler.kubernetes.io/queue"]
-	if queuePath == "" {
-		queuePath = fmt.Sprintf("root.%s", pod.Namespace)
+// Helper to remove pods that are no longer pending from the queue and the
+// queues below it, dropping capacity reserved for them
+func pruneQueuedPods(q *Queue, pending map[string]bool) {
+	for _, pod := range q.Pods.List() {
+		if !pending[podKey(pod)] {
+			q.Pods.Delete(pod)
+		}
 	}
-	queue := GetQueue(queuePath)
-	if queue == nil {
-		return
+	if q.reservedFor != "" && !pending[q.reservedFor] {
+		q.reservedFor = ""
+		q.reserved = nil
 	}
+	for _, child := range q.Children {
+		pruneQueuedPods(child, pending)
+	}
+}
+
+// Helper to schedule the head pod of a queue. When the head exceeds the queue's
+// capacity, capacity other queues borrowed from it is reclaimed, or if there
+// is none and the queue has lookahead enabled, the pods behind it are tried
+// instead. It returns false when no pod left the queue.
+func scheduleQueueHead(clientset kubernetes.Interface, config *rest.Config, queue *Queue, clusterTotal v1.ResourceList) bool {
 	if queue.ResourceUsage == nil {
 		queue.ResourceUsage = v1.ResourceList{}
 	}
-
-	clusterTotal, err := GetClusterTotalResources(clientset)
-	if err != nil {
-		fmt.Printf("Error getting cluster resources: %v\n", err)
-		return
+	// The queue policy decides which pending pod goes next
+	head := queue.Pods.Peek()
+	if head == nil {
+		return false
 	}
-	podReq := getPodResourceRequests(pod)
-	futureUsage := addResourceLists(queue.ResourceUsage, podReq)
-	if !isWithinCapacity(futureUsage, clusterTotal, queue) {
-		fmt.Printf("Queue %s exceeds capacity, cannot schedule pod %s\n", queuePath, pod.Name)
-		return
+	if err := validateExtendedResources(head); err != nil {
+		fmt.Printf("Pod %s cannot be scheduled: %v\n", head.Name, err)
+		Dequeue(queue.Path)
+		recordSchedulingFailure(clientset, head, err)
+		return true
+	}
+	podReq := getPodResourceRequests(head)
+	if err := checkQueueLimits(queue, podReq, clusterTotal); err != nil {
+		// Waiting would only block the pods behind it
+		fmt.Printf("Pod %s cannot be scheduled: %v\n", head.Name, err)
+		Dequeue(queue.Path)
+		recordSchedulingFailure(clientset, head, err)
+		return true
+	}
+	// Capacity reclaimed for the head already counts as used by its queue
+	needed := podReq
+	if queue.reservedFor == podKey(head) {
+		needed = subtractResourceLists(podReq, queue.reserved)
 	}
+	if !fitsQueueHierarchy(queue, needed, clusterTotal) {
+		fmt.Printf("Queue %s exceeds capacity, cannot schedule pod %s\n", queue.Path, head.Name)
+		if reclaimCapacity(clientset, config, queue, head, needed, clusterTotal) {
+			// The head goes first once the evicted pods have released their
+			// nodes, until then the capacity is held for it
+			queue.reservedFor = podKey(head)
+			queue.reserved = podReq
+			return false
+		}
+		return scheduleBehindHead(clientset, config, queue, head, clusterTotal)
+	}
+	queue.headBlockedPod = ""
 	// Debug log
-	fmt.Printf("Going ahead with scheduling pod %s in queue %s\n", pod.Name, queuePath)
+	fmt.Printf("Going ahead with scheduling pod %s in queue %s\n", head.Name, queue.Path)
 
 	// If within capacity, proceed to select node and bind
-	selected := Dequeue(queuePath)
-	// Debug for selected pod
-	fmt.Printf("Selected pod for scheduling: %v\n", selected)
-	if selected == nil {
-		return
+	selected := Dequeue(queue.Path)
+	bindQueuedPod(clientset, config, queue, selected, podReq, clusterTotal)
+	return true
+}
+
+// Helper to skip ahead of a head pod that exceeds capacity: the next
+// Config.Lookahead pods are tried in order and the first one that fits is
+// scheduled. Once the head has been blocked for Config.MaxHeadBypassSeconds no
+// more pods overtake it, so capacity that frees up is left for the head.
+func scheduleBehindHead(clientset kubernetes.Interface, config *rest.Config, queue *Queue, head *v1.Pod, clusterTotal v1.ResourceList) bool {
+	if queue.Config.Lookahead <= 0 {
+		return false
+	}
+	now := time.Now()
+	if queue.headBlockedPod != podKey(head) {
+		queue.headBlockedPod = podKey(head)
+		queue.headBlockedSince = now
+	}
+	if now.Sub(queue.headBlockedSince) >= getMaxHeadBypass(queue) {
+		fmt.Printf("Pod %s has been bypassed for too long, queue %s waits for it\n", head.Name, queue.Path)
+		return false
+	}
+	candidates := queue.Pods.Ordered(queue.Config.Lookahead + 1)
+	for _, pod := range candidates[1:] {
+		if validateExtendedResources(pod) != nil {
+			continue
+		}
+		podReq := getPodResourceRequests(pod)
+		if !fitsQueueHierarchy(queue, podReq, clusterTotal) {
+			continue
+		}
+		fmt.Printf("Pod %s in queue %s skips ahead of %s\n", pod.Name, queue.Path, head.Name)
+		queue.Pods.Delete(pod)
+		bindQueuedPod(clientset, config, queue, pod, podReq, clusterTotal)
+		return true
 	}
-	node, err := SelectBestNode(clientset)
+	return false
+}
+
+// Helper to get how long a blocked head pod may be bypassed
+func getMaxHeadBypass(queue *Queue) time.Duration {
+	if queue.Config.MaxHeadBypassSeconds <= 0 {
+		return DefaultMaxHeadBypass
+	}
+	return time.Duration(queue.Config.MaxHeadBypassSeconds) * time.Second
+}
+
+// Helper to place a pod taken off the queue on a node and account its usage
+func bindQueuedPod(clientset kubernetes.Interface, config *rest.Config, queue *Queue, selected *v1.Pod, podReq, clusterTotal v1.ResourceList) {
+	// Debug for selected pod
+	fmt.Printf("Selected pod for scheduling: %v\n", selected.Name)
+	node, err := SelectBestNode(clientset, selected)
 	if err != nil {
 		fmt.Printf("No suitable node: %v\n", err)
+		recordSchedulingFailure(clientset, selected, err)
 		return
 	}
 	err = BindPod(clientset, selected, node)
 	if err != nil {
 		fmt.Printf("Binding failed: %v\n", err)
-	} else {
-		fmt.Printf("Bound pod %s to node %s\n", selected.Name, node)
-		// Update queue resource usage
-		queue.ResourceUsage = addResourceLists(queue.ResourceUsage, podReq)
-
-		// Calculate usage percent for CPU and memory
-		cpuPercent := 0
-		memPercent := 0
-		totalCPU := clusterTotal[v1.ResourceCPU]
-		usedCPU := queue.ResourceUsage[v1.ResourceCPU]
-		totalMem := clusterTotal[v1.ResourceMemory]
-		usedMem := queue.ResourceUsage[v1.ResourceMemory]
-		if !totalCPU.IsZero() {
-			cpuPercent = int(float64(usedCPU.MilliValue()) / float64(totalCPU.MilliValue()) * 100)
-		}
-		if !totalMem.IsZero() {
-			memPercent = int(float64(usedMem.Value()) / float64(totalMem.Value()) * 100)
-		}
+		return
+	}
+	fmt.Printf("Bound pod %s to node %s\n", selected.Name, node)
+	// Update queue resource usage, the reservation for the pod now is usage
+	if queue.reservedFor == podKey(selected) {
+		queue.reservedFor = ""
+		queue.reserved = nil
+	}
+	queue.ResourceUsage = addResourceLists(queue.ResourceUsage, podReq)
+	if borrowed := getBorrowedResources(queue.ResourceUsage, getQueueGuarantee(queue, clusterTotal)); len(borrowed) > 0 {
+		fmt.Printf("Queue %s is borrowing %v above its guarantee\n", queue.Path, borrowed)
+	}
 
-		// Update Queue CRD status with both CPU and memory usage
-		err = update_status.UpdateQueueStatus(config, queue.Name, cpuPercent, memPercent)
-		if err != nil {
-			fmt.Printf("Failed to update queue status: %v\n", err)
-		}
+	// Update Queue CRD status with the usage of every resource
+	err = update_status.UpdateQueueStatus(config, queue.Name, buildQueueStatus(queue, clusterTotal))
+	if err != nil {
+		fmt.Printf("Failed to update queue status: %v\n", err)
+	}
+}
+
+// Helper to build the Queue CRD status from the queue's usage
+func buildQueueStatus(queue *Queue, clusterTotal v1.ResourceList) update_status.QueueStatus {
+	percents := getUsagePercents(queue.ResourceUsage, clusterTotal)
+	borrowed := getBorrowedResources(queue.ResourceUsage, getQueueGuarantee(queue, clusterTotal))
+	status := update_status.QueueStatus{
+		CPUUsage:      percents[v1.ResourceCPU],
+		MemoryUsage:   percents[v1.ResourceMemory],
+		ResourceUsage: map[string]int{},
+		Allocated:     map[string]string{},
+		Borrowed:      map[string]string{},
+		PendingPods:   queue.Pods.Len(),
+	}
+	for name, quantity := range borrowed {
+		status.Borrowed[string(name)] = quantity.String()
+	}
+	if since, ok := queue.Pods.OldestWaitingSince(); ok {
+		status.MaxWaitSeconds = int64(time.Since(since).Seconds())
+	}
+	for name, percent := range percents {
+		status.ResourceUsage[string(name)] = percent
+	}
+	for name, quantity := range queue.ResourceUsage {
+		status.Allocated[string(name)] = quantity.String()
 	}
+	return status
 }
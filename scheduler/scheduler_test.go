@@ -1,12 +1,28 @@
 package scheduler
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestEnqueueDequeue(t *testing.T) {
@@ -15,15 +31,15 @@ func TestEnqueueDequeue(t *testing.T) {
 	Enqueue(pod1)
 	Enqueue(pod2)
 
-	selected1 := Dequeue("ns1")
+	selected1 := Dequeue("root.ns1")
 	if selected1 == nil || selected1.Name != "pod1" {
 		t.Errorf("Expected pod1 to be dequeued first, got %v", selected1)
 	}
-	selected2 := Dequeue("ns1")
+	selected2 := Dequeue("root.ns1")
 	if selected2 == nil || selected2.Name != "pod2" {
 		t.Errorf("Expected pod2 to be dequeued second, got %v", selected2)
 	}
-	selected3 := Dequeue("ns1")
+	selected3 := Dequeue("root.ns1")
 	if selected3 != nil {
 		t.Errorf("Expected nil when queue is empty, got %v", selected3)
 	}
@@ -31,7 +47,7 @@ func TestEnqueueDequeue(t *testing.T) {
 
 func TestSelectBestNode_NoNodes(t *testing.T) {
 	clientset := fake.NewSimpleClientset() // No nodes added
-	_, err := SelectBestNode(clientset)
+	_, err := SelectBestNode(clientset, &v1.Pod{})
 	if err == nil {
 		t.Error("Expected error when no nodes are available")
 	}
@@ -49,7 +65,7 @@ func TestSelectBestNode_ReadyNode(t *testing.T) {
 			},
 		},
 	)
-	node, err := SelectBestNode(clientset)
+	node, err := SelectBestNode(clientset, &v1.Pod{})
 	if err != nil {
 		t.Errorf("Expected to find a ready node, got error: %v", err)
 	}
@@ -58,12 +74,339 @@ func TestSelectBestNode_ReadyNode(t *testing.T) {
 	}
 }
 
+func TestSelectBestNode_ResourceFit(t *testing.T) {
+	allocatable := v1.ResourceList{
+		v1.ResourceCPU:    resourceMustParse("1"),
+		v1.ResourceMemory: resourceMustParse("1Gi"),
+	}
+	ready := []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	clientset := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "full-node"},
+			Status:     v1.NodeStatus{Allocatable: allocatable, Conditions: ready},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "free-node"},
+			Status:     v1.NodeStatus{Allocatable: allocatable, Conditions: ready},
+		},
+		// Already bound pod using most of full-node's CPU
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "default"},
+			Spec: v1.PodSpec{
+				NodeName: "full-node",
+				Containers: []v1.Container{{
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceCPU: resourceMustParse("800m")},
+					},
+				}},
+			},
+		},
+		// Finished pods should not count against the node
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "finished", Namespace: "default"},
+			Spec: v1.PodSpec{
+				NodeName: "free-node",
+				Containers: []v1.Container{{
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceCPU: resourceMustParse("1")},
+					},
+				}},
+			},
+			Status: v1.PodStatus{Phase: v1.PodSucceeded},
+		},
+	)
+
+	node, err := SelectBestNode(clientset, podWithRequests(v1.ResourceList{v1.ResourceCPU: resourceMustParse("500m")}))
+	if err != nil {
+		t.Fatalf("Expected a node with room for the pod, got error: %v", err)
+	}
+	if node != "free-node" {
+		t.Errorf("Expected free-node, got %s", node)
+	}
+
+	_, err = SelectBestNode(clientset, podWithRequests(v1.ResourceList{v1.ResourceMemory: resourceMustParse("2Gi")}))
+	if err == nil {
+		t.Error("Expected error when no node has enough memory")
+	}
+}
+
+func TestSelectBestNode_TaintToleration(t *testing.T) {
+	ready := []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	clientset := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "control-plane"},
+			Spec: v1.NodeSpec{Taints: []v1.Taint{{
+				Key:    "node-role.kubernetes.io/control-plane",
+				Effect: v1.TaintEffectNoSchedule,
+			}}},
+			Status: v1.NodeStatus{Conditions: ready},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "gpu-node"},
+			Spec: v1.NodeSpec{Taints: []v1.Taint{{
+				Key:    "pool",
+				Value:  "gpu",
+				Effect: v1.TaintEffectNoExecute,
+			}}},
+			Status: v1.NodeStatus{Conditions: ready},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "soft-tainted"},
+			Spec: v1.NodeSpec{Taints: []v1.Taint{{
+				Key:    "pool",
+				Value:  "spot",
+				Effect: v1.TaintEffectPreferNoSchedule,
+			}}},
+			Status: v1.NodeStatus{Conditions: ready},
+		},
+	)
+
+	// PreferNoSchedule taints do not filter nodes
+	node, err := SelectBestNode(clientset, &v1.Pod{})
+	if err != nil || node != "soft-tainted" {
+		t.Errorf("Expected soft-tainted for pod without tolerations, got %q (err: %v)", node, err)
+	}
+
+	// Equal toleration with matching key, value and effect
+	gpuPod := &v1.Pod{Spec: v1.PodSpec{Tolerations: []v1.Toleration{{
+		Key:      "pool",
+		Operator: v1.TolerationOpEqual,
+		Value:    "gpu",
+		Effect:   v1.TaintEffectNoExecute,
+	}}}}
+	node, err = SelectBestNode(clientset, gpuPod)
+	if err != nil || node != "gpu-node" {
+		t.Errorf("Expected gpu-node for pod tolerating the gpu taint, got %q (err: %v)", node, err)
+	}
+
+	// Wrong value does not tolerate the taint
+	wrongValue := &v1.Pod{Spec: v1.PodSpec{Tolerations: []v1.Toleration{{
+		Key:      "pool",
+		Operator: v1.TolerationOpEqual,
+		Value:    "cpu",
+	}}}}
+	node, _ = SelectBestNode(clientset, wrongValue)
+	if node == "gpu-node" {
+		t.Error("Toleration with a different value should not tolerate the gpu taint")
+	}
+
+	// Empty key with Exists tolerates every taint
+	tolerateAll := &v1.Pod{Spec: v1.PodSpec{Tolerations: []v1.Toleration{{
+		Operator: v1.TolerationOpExists,
+	}}}}
+	node, err = SelectBestNode(clientset, tolerateAll)
+	if err != nil || node != "control-plane" {
+		t.Errorf("Expected control-plane for pod tolerating everything, got %q (err: %v)", node, err)
+	}
+
+	// Effect mismatch does not tolerate the taint
+	wrongEffect := &v1.Pod{Spec: v1.PodSpec{Tolerations: []v1.Toleration{{
+		Key:      "node-role.kubernetes.io/control-plane",
+		Operator: v1.TolerationOpExists,
+		Effect:   v1.TaintEffectNoExecute,
+	}}}}
+	node, _ = SelectBestNode(clientset, wrongEffect)
+	if node == "control-plane" {
+		t.Error("Toleration with a different effect should not tolerate the control-plane taint")
+	}
+}
+
+func TestSelectBestNode_NodeSelectorAndAffinity(t *testing.T) {
+	ready := []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	clientset := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "zone-a", Labels: map[string]string{
+				"topology.kubernetes.io/zone":      "a",
+				"node.kubernetes.io/instance-type": "m5.large",
+			}},
+			Status: v1.NodeStatus{Conditions: ready},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "zone-b", Labels: map[string]string{
+				"topology.kubernetes.io/zone":      "b",
+				"node.kubernetes.io/instance-type": "m5.xlarge",
+			}},
+			Status: v1.NodeStatus{Conditions: ready},
+		},
+	)
+
+	selectorPod := &v1.Pod{Spec: v1.PodSpec{NodeSelector: map[string]string{
+		"topology.kubernetes.io/zone": "b",
+	}}}
+	node, err := SelectBestNode(clientset, selectorPod)
+	if err != nil || node != "zone-b" {
+		t.Errorf("Expected zone-b for nodeSelector zone=b, got %q (err: %v)", node, err)
+	}
+
+	affinityPod := func(terms ...v1.NodeSelectorTerm) *v1.Pod {
+		return &v1.Pod{Spec: v1.PodSpec{Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: terms},
+		}}}}
+	}
+	node, err = SelectBestNode(clientset, affinityPod(v1.NodeSelectorTerm{
+		MatchExpressions: []v1.NodeSelectorRequirement{{
+			Key:      "node.kubernetes.io/instance-type",
+			Operator: v1.NodeSelectorOpNotIn,
+			Values:   []string{"m5.large"},
+		}},
+	}))
+	if err != nil || node != "zone-b" {
+		t.Errorf("Expected zone-b for NotIn m5.large, got %q (err: %v)", node, err)
+	}
+
+	node, err = SelectBestNode(clientset, affinityPod(v1.NodeSelectorTerm{
+		MatchFields: []v1.NodeSelectorRequirement{{
+			Key:      "metadata.name",
+			Operator: v1.NodeSelectorOpIn,
+			Values:   []string{"zone-b"},
+		}},
+	}))
+	if err != nil || node != "zone-b" {
+		t.Errorf("Expected zone-b for matchFields metadata.name, got %q (err: %v)", node, err)
+	}
+
+	_, err = SelectBestNode(clientset, affinityPod(v1.NodeSelectorTerm{
+		MatchExpressions: []v1.NodeSelectorRequirement{{
+			Key:      "topology.kubernetes.io/zone",
+			Operator: v1.NodeSelectorOpIn,
+			Values:   []string{"c"},
+		}},
+	}))
+	if err == nil {
+		t.Error("Expected error when no node is in zone c")
+	}
+}
+
+func TestMatchNodeSelectorRequirement(t *testing.T) {
+	labels := map[string]string{"zone": "a", "cores": "16"}
+	tests := []struct {
+		name string
+		req  v1.NodeSelectorRequirement
+		want bool
+	}{
+		{"In match", v1.NodeSelectorRequirement{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"a", "b"}}, true},
+		{"In no match", v1.NodeSelectorRequirement{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"b"}}, false},
+		{"In missing key", v1.NodeSelectorRequirement{Key: "rack", Operator: v1.NodeSelectorOpIn, Values: []string{"a"}}, false},
+		{"NotIn match", v1.NodeSelectorRequirement{Key: "zone", Operator: v1.NodeSelectorOpNotIn, Values: []string{"b"}}, true},
+		{"NotIn missing key", v1.NodeSelectorRequirement{Key: "rack", Operator: v1.NodeSelectorOpNotIn, Values: []string{"a"}}, true},
+		{"NotIn no match", v1.NodeSelectorRequirement{Key: "zone", Operator: v1.NodeSelectorOpNotIn, Values: []string{"a"}}, false},
+		{"Exists", v1.NodeSelectorRequirement{Key: "zone", Operator: v1.NodeSelectorOpExists}, true},
+		{"Exists missing key", v1.NodeSelectorRequirement{Key: "rack", Operator: v1.NodeSelectorOpExists}, false},
+		{"DoesNotExist", v1.NodeSelectorRequirement{Key: "rack", Operator: v1.NodeSelectorOpDoesNotExist}, true},
+		{"DoesNotExist present key", v1.NodeSelectorRequirement{Key: "zone", Operator: v1.NodeSelectorOpDoesNotExist}, false},
+		{"Gt match", v1.NodeSelectorRequirement{Key: "cores", Operator: v1.NodeSelectorOpGt, Values: []string{"8"}}, true},
+		{"Gt no match", v1.NodeSelectorRequirement{Key: "cores", Operator: v1.NodeSelectorOpGt, Values: []string{"16"}}, false},
+		{"Lt match", v1.NodeSelectorRequirement{Key: "cores", Operator: v1.NodeSelectorOpLt, Values: []string{"32"}}, true},
+		{"Lt non-integer label", v1.NodeSelectorRequirement{Key: "zone", Operator: v1.NodeSelectorOpLt, Values: []string{"32"}}, false},
+	}
+	for _, tt := range tests {
+		if got := matchNodeSelectorRequirement(tt.req, labels); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	// An empty term matches nothing
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n1", Labels: labels}}
+	if nodeMatchesNodeSelectorTerms(node, []v1.NodeSelectorTerm{{}}) {
+		t.Error("Empty node selector term should not match any node")
+	}
+}
+
+func TestSelectBestNode_ScoringStrategyPerQueue(t *testing.T) {
+	rootQueue.Children = make(map[string]*Queue)
+	CreateQueue("", "root.batch", QueueConfig{Capacity: 50, MaxCapacity: 100, Policy: "fifo", ScoringStrategy: MostAllocated})
+	CreateQueue("", "root.latency", QueueConfig{Capacity: 50, MaxCapacity: 100, Policy: "fifo", ScoringStrategy: LeastAllocated})
+	CreateQueue("", "root.balanced", QueueConfig{Capacity: 50, MaxCapacity: 100, Policy: "fifo", ScoringStrategy: BalancedAllocation})
+
+	allocatable := v1.ResourceList{
+		v1.ResourceCPU:    resourceMustParse("4"),
+		v1.ResourceMemory: resourceMustParse("8Gi"),
+	}
+	node := func(name string) *v1.Node {
+		n := readyNode(name, nil)
+		n.Status.Allocatable = allocatable
+		return n
+	}
+	boundPod := func(name, nodeName string, requests v1.ResourceList) *v1.Pod {
+		p := podWithRequests(requests)
+		p.Name = name
+		p.Namespace = "default"
+		p.Spec.NodeName = nodeName
+		return p
+	}
+	clientset := fake.NewSimpleClientset(
+		node("busy"), node("empty"), node("skewed"),
+		boundPod("p1", "busy", v1.ResourceList{
+			v1.ResourceCPU:    resourceMustParse("2"),
+			v1.ResourceMemory: resourceMustParse("4Gi"),
+		}),
+		boundPod("p2", "skewed", v1.ResourceList{
+			v1.ResourceCPU: resourceMustParse("3"),
+		}),
+	)
+
+	cpuAndMemory := v1.ResourceList{
+		v1.ResourceCPU:    resourceMustParse("1"),
+		v1.ResourceMemory: resourceMustParse("2Gi"),
+	}
+	memoryOnly := v1.ResourceList{
+		v1.ResourceMemory: resourceMustParse("6Gi"),
+	}
+	queuedPod := func(queue string, requests v1.ResourceList) *v1.Pod {
+		p := podWithRequests(requests)
+		p.Annotations = map[string]string{"scheduler.kubernetes.io/queue": queue}
+		return p
+	}
+
+	tests := []struct {
+		queue    string
+		requests v1.ResourceList
+		want     string
+	}{
+		{"root.batch", cpuAndMemory, "busy"},
+		{"root.latency", cpuAndMemory, "empty"},
+		// The memory-heavy pod evens out the CPU-heavy skewed node
+		{"root.balanced", memoryOnly, "skewed"},
+	}
+	for _, tt := range tests {
+		got, err := SelectBestNode(clientset, queuedPod(tt.queue, tt.requests))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.queue, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.queue, tt.want, got)
+		}
+	}
+}
+
+func TestAllocationScores(t *testing.T) {
+	allocatable := v1.ResourceList{
+		v1.ResourceCPU:    resourceMustParse("4"),
+		v1.ResourceMemory: resourceMustParse("8Gi"),
+	}
+	requested := v1.ResourceList{
+		v1.ResourceCPU:    resourceMustParse("1"),
+		v1.ResourceMemory: resourceMustParse("6Gi"),
+	}
+	if got := leastAllocatedScore(requested, allocatable); got != 50 {
+		t.Errorf("Expected LeastAllocated score 50, got %d", got)
+	}
+	if got := mostAllocatedScore(requested, allocatable); got != 50 {
+		t.Errorf("Expected MostAllocated score 50, got %d", got)
+	}
+	// Fractions 0.25 and 0.75 have a standard deviation of 0.25
+	if got := balancedAllocationScore(requested, allocatable); got != 75 {
+		t.Errorf("Expected BalancedAllocation score 75, got %d", got)
+	}
+}
+
 func TestCustomQueue(t *testing.T) {
 	// Reset rootQueue for test isolation
 	rootQueue.Children = make(map[string]*Queue)
 
 	// Create a custom queue hierarchy
-	err := CreateQueue("root.teamA.subteam1", QueueConfig{Capacity: 30, MaxCapacity: 50, Policy: "fifo"})
+	err := CreateQueue("", "root.teamA.subteam1", QueueConfig{Capacity: 30, MaxCapacity: 50, Policy: "fifo"})
 	if err != nil {
 		t.Fatalf("Failed to create custom queue: %v", err)
 	}
@@ -91,36 +434,1058 @@ func TestCustomQueue(t *testing.T) {
 
 	// Check podCustom is in the correct custom queue
 	qCustom := GetQueue("root.teamA.subteam1")
-	if qCustom == nil || len(qCustom.Pods) != 1 || qCustom.Pods[0].Name != "pod-custom" {
+	if qCustom == nil || qCustom.Pods.Len() != 1 || qCustom.Pods.Peek().Name != "pod-custom" {
 		t.Errorf("pod-custom not found in custom queue")
 	}
 
 	// Check podDefault is in the default namespace queue
 	qDefault := GetQueue("root.ns-default")
-	if qDefault == nil || len(qDefault.Pods) != 1 || qDefault.Pods[0].Name != "pod-default" {
+	if qDefault == nil || qDefault.Pods.Len() != 1 || qDefault.Pods.Peek().Name != "pod-default" {
 		t.Errorf("pod-default not found in default namespace queue")
 	}
 
 	// Dequeue from custom queue
-	deqCustom := qCustom.Pods[0]
+	deqCustom := qCustom.Pods.Peek()
 	if deqCustom.Name != "pod-custom" {
 		t.Errorf("Expected pod-custom, got %s", deqCustom.Name)
 	}
 
 	// Dequeue from default queue
-	deqDefault := Dequeue("ns-default")
+	deqDefault := Dequeue("root.ns-default")
 	if deqDefault == nil || deqDefault.Name != "pod-default" {
 		t.Errorf("Expected pod-default, got %v", deqDefault)
 	}
 }
 
+func TestQueuePathOutsideRoot(t *testing.T) {
+	resetQueues()
+	annotated := func(name, queuePath string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "ns-a",
+			Annotations: map[string]string{"scheduler.kubernetes.io/queue": queuePath},
+		}}
+	}
+
+	// A path without the root prefix names the queue below root
+	Enqueue(annotated("short", "team-a.dev"))
+	if q := GetQueue("root.team-a.dev"); q == nil || q.Pods.Len() != 1 {
+		t.Errorf("Expected team-a.dev to be created as root.team-a.dev")
+	}
+	// Root itself and malformed paths fall back to the namespace queue
+	Enqueue(annotated("on-root", "root"))
+	Enqueue(annotated("malformed", "team-a..dev"))
+	if q := GetQueue("root.ns-a"); q == nil || q.Pods.Len() != 2 {
+		t.Errorf("Expected root and malformed paths to use the namespace queue")
+	}
+	if rootQueue.Pods.Len() != 0 {
+		t.Errorf("Expected no pod queued on root, got %d", rootQueue.Pods.Len())
+	}
+	if err := CreateQueue("", "team-b", QueueConfig{}); err == nil {
+		t.Error("Expected CreateQueue to reject a path outside root")
+	}
+}
+
+func TestPriorityPolicy(t *testing.T) {
+	resetQueues()
+	CreateQueue("", "root.prio", QueueConfig{Capacity: 100, MaxCapacity: 100, Policy: PolicyPriority})
+	UpdatePriorityClass(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "hotfix"}, Value: 1000})
+	UpdatePriorityClass(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Value: 10, GlobalDefault: true})
+	t.Cleanup(func() {
+		DeletePriorityClass(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "hotfix"}})
+		DeletePriorityClass(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	})
+
+	now := time.Now()
+	newPod := func(name string, created time.Time, priority *int32, class string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "prio",
+				CreationTimestamp: metav1.NewTime(created),
+			},
+			Spec: v1.PodSpec{Priority: priority, PriorityClassName: class},
+		}
+	}
+	low := int32(0)
+	nightly := newPod("nightly", now.Add(-time.Hour), &low, "")
+	nightly2 := newPod("nightly-2", now.Add(-2*time.Hour), &low, "")
+	// Without spec.priority the values come from the PriorityClass, or the global default
+	hotfix := newPod("hotfix", now, nil, "hotfix")
+	regular := newPod("regular", now, nil, "")
+	for _, pod := range []*v1.Pod{nightly, nightly2, hotfix, regular, nightly} {
+		Enqueue(pod)
+	}
+
+	queue := GetQueue("root.prio")
+	if queue.Pods.Len() != 4 {
+		t.Fatalf("Expected re-enqueued pod to be queued once, got %d pods", queue.Pods.Len())
+	}
+	for _, expected := range []string{"hotfix", "regular", "nightly-2", "nightly"} {
+		pod := Dequeue("root.prio")
+		if pod == nil || pod.Name != expected {
+			t.Fatalf("Expected %s to be dequeued, got %v", expected, pod)
+		}
+	}
+
+	// Switching back to FIFO re-sorts the pods already queued
+	Enqueue(nightly)
+	Enqueue(hotfix)
+	queue.Pods.SetPolicy(PolicyFIFO)
+	if pod := Dequeue("root.prio"); pod == nil || pod.Name != "nightly" {
+		t.Errorf("Expected nightly first under fifo, got %v", pod)
+	}
+}
+
+func TestFairSharePolicy(t *testing.T) {
+	resetQueues()
+	rootQueue.Config.Policy = PolicyFair
+	t.Cleanup(func() { rootQueue.Config.Policy = PolicyFIFO })
+	// No borrowing, so teamA stops at its capacity
+	CreateQueue("", "root.teamA", QueueConfig{Capacity: 50, MaxCapacity: 50, Policy: PolicyFIFO})
+	CreateQueue("", "root.teamB", QueueConfig{Capacity: 50, MaxCapacity: 50, Policy: PolicyFIFO})
+	clusterTotal := v1.ResourceList{v1.ResourceCPU: resourceMustParse("10")}
+
+	var objects []runtime.Object
+	var pods []*v1.Pod
+	// teamA submits its whole backlog before teamB submits anything
+	for i := 0; i < 6; i++ {
+		pods = append(pods, pendingPodInQueue(fmt.Sprintf("a-%d", i), "root.teamA", "1"))
+	}
+	for i := 0; i < 2; i++ {
+		pods = append(pods, pendingPodInQueue(fmt.Sprintf("b-%d", i), "root.teamB", "1"))
+	}
+	node := readyNode("node1", nil)
+	node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("10")}
+	objects = append(objects, node)
+	for _, pod := range pods {
+		objects = append(objects, pod)
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+
+	for _, pod := range pods {
+		Enqueue(pod)
+	}
+	teamA, teamB := GetQueue("root.teamA"), GetQueue("root.teamB")
+	teamA.ResourceUsage = v1.ResourceList{v1.ResourceCPU: resourceMustParse("2")}
+	if leaf := SelectLeafQueue(clusterTotal, nil); leaf != teamB {
+		t.Errorf("Expected idle teamB to be served before teamA, got %v", leaf.Path)
+	}
+	teamB.ResourceUsage = v1.ResourceList{v1.ResourceCPU: resourceMustParse("3")}
+	if leaf := SelectLeafQueue(clusterTotal, nil); leaf != teamA {
+		t.Errorf("Expected teamA at 40%% of its share to be served before teamB at 60%%, got %v", leaf.Path)
+	}
+	teamA.ResourceUsage = v1.ResourceList{}
+	teamB.ResourceUsage = v1.ResourceList{}
+
+	ScheduleCycle(clientset, &rest.Config{Host: "http://127.0.0.1:1"}, pods)
+	var bound []string
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "create" && action.GetSubresource() == "binding" {
+			bound = append(bound, action.(k8stesting.CreateAction).GetObject().(*v1.Binding).Name)
+		}
+	}
+	expected := []string{"a-0", "b-0", "a-1", "b-1", "a-2", "a-3", "a-4"}
+	if strings.Join(bound, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected bindings %v, got %v", expected, bound)
+	}
+	// a-5 exceeds teamA's capacity and stays queued
+	if teamA.Pods.Len() != 1 || teamA.Pods.Peek().Name != "a-5" {
+		t.Errorf("Expected a-5 to wait in teamA, got %d pods", teamA.Pods.Len())
+	}
+
+	// A parent without headroom is not descended into
+	CreateQueue("", "root.org", QueueConfig{Capacity: 20, MaxCapacity: 20, Policy: PolicyFair})
+	CreateQueue("", "root.org.a", QueueConfig{Capacity: 100, MaxCapacity: 100})
+	CreateQueue("", "root.org.b", QueueConfig{Capacity: 100, MaxCapacity: 100})
+	GetQueue("root.org.a").ResourceUsage = v1.ResourceList{v1.ResourceCPU: resourceMustParse("3")}
+	teamA.Pods.PopPod()
+	Enqueue(pendingPodInQueue("org-b", "root.org.b", "1"))
+	if leaf := SelectLeafQueue(clusterTotal, nil); leaf != nil {
+		t.Errorf("Expected no queue with headroom, got %v", leaf.Path)
+	}
+	if fitsQueueHierarchy(GetQueue("root.org.b"), v1.ResourceList{v1.ResourceCPU: resourceMustParse("1")}, clusterTotal) {
+		t.Errorf("Expected parent capacity to be enforced for child queues")
+	}
+}
+
+// Helper for test: drop every queue below root
+func resetQueues() {
+	rootQueue.Children = make(map[string]*Queue)
+	rootQueue.ResourceUsage = v1.ResourceList{}
+	queues = map[string]*Queue{"root": rootQueue}
+}
+
+// Helper for test: pending pod requesting cpu in the given queue
+func pendingPodInQueue(name, queuePath, cpu string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{"scheduler.kubernetes.io/queue": queuePath},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name:      "c",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resourceMustParse(cpu)}},
+			}},
+		},
+	}
+}
+
+func TestWatcherUpdates(t *testing.T) {
+	resetQueues()
+	CreateQueue("", "root.prio", QueueConfig{Capacity: 100, MaxCapacity: 100, Policy: PolicyPriority})
+	UpdatePriorityClass(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "batch"}, Value: 1})
+	UpdatePriorityClass(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "urgent"}, Value: 5})
+	t.Cleanup(func() {
+		DeletePriorityClass(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "batch"}})
+		DeletePriorityClass(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "urgent"}})
+	})
+	podOfClass := func(name, class string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "prio"},
+			Spec:       v1.PodSpec{PriorityClassName: class},
+		}
+	}
+	Enqueue(podOfClass("report", "batch"))
+	Enqueue(podOfClass("alert", "urgent"))
+	queue := GetQueue("root.prio")
+	if head := queue.Pods.Peek(); head.Name != "alert" {
+		t.Fatalf("Expected alert first, got %s", head.Name)
+	}
+	// A changed PriorityClass value re-sorts the pods already queued
+	UpdatePriorityClass(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "batch"}, Value: 100})
+	if head := queue.Pods.Peek(); head.Name != "report" {
+		t.Errorf("Expected report first after batch was raised, got %s", head.Name)
+	}
+
+	// Queue CRD changes are applied by the scheduling goroutine, between cycles
+	policyEvent := func(policy string) watch.Event {
+		return watch.Event{Type: watch.Modified, Object: &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "prio"},
+			"spec":     map[string]interface{}{"path": "root.prio", "capacity": int64(100), "policy": policy},
+		}}}
+	}
+	clientset := fake.NewSimpleClientset()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			policy := PolicyFIFO
+			if i%2 == 1 {
+				policy = PolicyPriority
+			}
+			handleQueueEvent(policyEvent(policy))
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		ScheduleCycle(clientset, &rest.Config{Host: "http://127.0.0.1:1"}, []*v1.Pod{podOfClass(fmt.Sprintf("job-%d", i), "batch")})
+	}
+	<-done
+	applyStateUpdates()
+	if queue.Config.Policy != PolicyPriority {
+		t.Errorf("Expected the last policy change to win, got %s", queue.Config.Policy)
+	}
+}
+
+func TestDRFPolicy(t *testing.T) {
+	resetQueues()
+	rootQueue.Config.Policy = PolicyDRF
+	t.Cleanup(func() { rootQueue.Config.Policy = PolicyFIFO })
+	CreateQueue("", "root.etl", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	CreateQueue("", "root.cache", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	clusterTotal := v1.ResourceList{
+		v1.ResourceCPU:    resourceMustParse("100"),
+		v1.ResourceMemory: resourceMustParse("100Gi"),
+	}
+	etl, cache := GetQueue("root.etl"), GetQueue("root.cache")
+	// CPU-heavy ETL dominates on CPU, memory-heavy caching on memory
+	etl.ResourceUsage = v1.ResourceList{v1.ResourceCPU: resourceMustParse("30"), v1.ResourceMemory: resourceMustParse("10Gi")}
+	cache.ResourceUsage = v1.ResourceList{v1.ResourceCPU: resourceMustParse("5"), v1.ResourceMemory: resourceMustParse("40Gi")}
+	Enqueue(pendingPodInQueue("cache-1", "root.cache", "1"))
+	Enqueue(pendingPodInQueue("etl-1", "root.etl", "1"))
+
+	if share := dominantShare(etl, clusterTotal); share < 0.299 || share > 0.301 {
+		t.Errorf("Expected etl dominant share 0.3, got %f", share)
+	}
+	if share := dominantShare(cache, clusterTotal); share < 0.399 || share > 0.401 {
+		t.Errorf("Expected cache dominant share 0.4, got %f", share)
+	}
+	if leaf := SelectLeafQueue(clusterTotal, nil); leaf != etl {
+		t.Errorf("Expected etl with the smaller dominant share, got %v", leaf.Path)
+	}
+	// Fairness weighs every guaranteed resource: cache uses 80% of its memory
+	// guarantee, more than the 60% of its cpu guarantee etl uses
+	rootQueue.Config.Policy = PolicyFair
+	if ratio := fairShareRatio(cache, clusterTotal); ratio < 0.799 || ratio > 0.801 {
+		t.Errorf("Expected cache fair share ratio 0.8, got %f", ratio)
+	}
+	if leaf := SelectLeafQueue(clusterTotal, nil); leaf != etl {
+		t.Errorf("Expected fair policy to pick etl, got %v", leaf.Path)
+	}
+	// Without a guarantee the share of the parent's guarantee is used instead
+	CreateQueue("", "root.adhoc", QueueConfig{MaxCapacity: 100})
+	adhoc := GetQueue("root.adhoc")
+	adhoc.ResourceUsage = v1.ResourceList{v1.ResourceCPU: resourceMustParse("20")}
+	if ratio := fairShareRatio(adhoc, clusterTotal); ratio < 0.199 || ratio > 0.201 {
+		t.Errorf("Expected adhoc fair share ratio 0.2 of root, got %f", ratio)
+	}
+}
+
+func TestWRRPolicy(t *testing.T) {
+	resetQueues()
+	rootQueue.Config.Policy = PolicyWRR
+	t.Cleanup(func() { rootQueue.Config.Policy = PolicyFIFO })
+	for _, name := range []string{"interactive", "batch"} {
+		UpdateQueueState(&unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": name},
+			"spec": map[string]interface{}{
+				"path":     "root." + name,
+				"capacity": int64(100),
+				"weight":   map[string]int64{"interactive": 4, "batch": 1}[name],
+			},
+		}})
+	}
+	if w := GetQueue("root.interactive").Config.Weight; w != 4 {
+		t.Fatalf("Expected weight 4 parsed from the CRD, got %d", w)
+	}
+
+	node := readyNode("node1", nil)
+	node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("100")}
+	objects := []runtime.Object{node}
+	var pods []*v1.Pod
+	// The batch backlog was submitted first
+	for i := 0; i < 10; i++ {
+		pods = append(pods, pendingPodInQueue(fmt.Sprintf("batch-%d", i), "root.batch", "1"))
+	}
+	for i := 0; i < 10; i++ {
+		pods = append(pods, pendingPodInQueue(fmt.Sprintf("interactive-%d", i), "root.interactive", "1"))
+	}
+	for _, pod := range pods {
+		objects = append(objects, pod)
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	ScheduleCycle(clientset, &rest.Config{Host: "http://127.0.0.1:1"}, pods)
+
+	var order []string
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "create" && action.GetSubresource() == "binding" {
+			name := action.(k8stesting.CreateAction).GetObject().(*v1.Binding).Name
+			order = append(order, strings.Split(name, "-")[0])
+		}
+	}
+	expected := "interactive,interactive,batch,interactive,interactive,interactive,interactive,batch,interactive,interactive"
+	if got := strings.Join(order[:10], ","); got != expected {
+		t.Errorf("Expected interactive served 4x as often as batch, got %s", got)
+	}
+	if len(order) != 20 {
+		t.Errorf("Expected all 20 pods bound once the interactive queue drained, got %d", len(order))
+	}
+}
+
+func TestDeadlinePolicy(t *testing.T) {
+	resetQueues()
+	CreateQueue("", "root.reports", QueueConfig{Capacity: 100, MaxCapacity: 100, Policy: PolicyDeadline})
+	now := time.Now()
+	withDeadline := func(name, deadline string) *v1.Pod {
+		pod := pendingPodInQueue(name, "root.reports", "1")
+		if deadline != "" {
+			pod.Annotations[DeadlineAnnotation] = deadline
+		}
+		return pod
+	}
+	pods := []*v1.Pod{
+		withDeadline("adhoc", ""),
+		withDeadline("weekly", now.Add(48*time.Hour).Format(time.RFC3339)),
+		withDeadline("daily", now.Add(time.Hour).Format(time.RFC3339)),
+		withDeadline("broken", "tomorrow"),
+		withDeadline("overdue", now.Add(-time.Minute).Format(time.RFC3339)),
+	}
+	for _, pod := range pods {
+		Enqueue(pod)
+	}
+	for _, expected := range []string{"overdue", "daily", "weekly", "adhoc", "broken"} {
+		pod := Dequeue("root.reports")
+		if pod == nil || pod.Name != expected {
+			t.Fatalf("Expected %s to be dequeued, got %v", expected, pod)
+		}
+	}
+
+	clientset := fake.NewSimpleClientset()
+	recordDeadlineExceeded(clientset, pods, now)
+	recordDeadlineExceeded(clientset, pods, now)
+	events, _ := clientset.CoreV1().Events("default").List(context.TODO(), metav1.ListOptions{})
+	if len(events.Items) != 1 || events.Items[0].InvolvedObject.Name != "overdue" || events.Items[0].Reason != "DeadlineExceeded" {
+		t.Errorf("Expected one DeadlineExceeded event for overdue, got %+v", events.Items)
+	}
+	// Once the pod is no longer pending it is forgotten
+	recordDeadlineExceeded(clientset, nil, now)
+	if len(deadlineExceededReported) != 0 {
+		t.Errorf("Expected reported deadlines to be pruned, got %v", deadlineExceededReported)
+	}
+}
+
+func TestAging(t *testing.T) {
+	resetQueues()
+	CreateQueue("", "root.shared", QueueConfig{Capacity: 100, MaxCapacity: 100, Policy: PolicyPriority})
+	now := time.Now()
+	low, high := int32(0), int32(1000)
+	starving := pendingPodInQueue("starving", "root.shared", "1")
+	starving.CreationTimestamp = metav1.NewTime(now.Add(-2 * time.Hour))
+	starving.Spec.Priority = &low
+	fresh := pendingPodInQueue("fresh", "root.shared", "1")
+	fresh.CreationTimestamp = metav1.NewTime(now)
+	fresh.Spec.Priority = &high
+	Enqueue(starving)
+	Enqueue(fresh)
+
+	shared := GetQueue("root.shared")
+	if pod := shared.Pods.Peek(); pod.Name != "fresh" {
+		t.Errorf("Expected higher priority first without aging, got %s", pod.Name)
+	}
+	// 10 points per minute for two hours outweighs 1000 points of priority
+	shared.Pods.SetAgingRate(10)
+	if pod := shared.Pods.Peek(); pod.Name != "starving" {
+		t.Errorf("Expected the aged pod first, got %s", pod.Name)
+	}
+
+	// Under fair, a long wait lowers the share a child is compared with
+	rootQueue.Config.Policy = PolicyFair
+	t.Cleanup(func() {
+		rootQueue.Config.Policy = PolicyFIFO
+		rootQueue.Config.AgingRate = 0
+	})
+	CreateQueue("", "root.busy", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	busy := GetQueue("root.busy")
+	busy.ResourceUsage = v1.ResourceList{v1.ResourceCPU: resourceMustParse("5")}
+	shared.ResourceUsage = v1.ResourceList{v1.ResourceCPU: resourceMustParse("40")}
+	clusterTotal := v1.ResourceList{v1.ResourceCPU: resourceMustParse("100")}
+	Enqueue(pendingPodInQueue("busy-1", "root.busy", "1"))
+	if leaf := SelectLeafQueue(clusterTotal, nil); leaf != busy {
+		t.Errorf("Expected busy below its share first without aging, got %s", leaf.Path)
+	}
+	rootQueue.Config.AgingRate = 1
+	if leaf := SelectLeafQueue(clusterTotal, nil); leaf != shared {
+		t.Errorf("Expected shared with a pod waiting two hours first, got %s", leaf.Path)
+	}
+
+	updateQueueMetrics(now)
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, expected := range []string{
+		`kubescheduler_queue_pending_pods{queue="root.shared"} 2`,
+		`kubescheduler_queue_max_wait_seconds{queue="root.shared"} 7200`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected metrics to contain %s, got:\n%s", expected, body)
+		}
+	}
+	if status := buildQueueStatus(shared, clusterTotal); status.PendingPods != 2 || status.MaxWaitSeconds < 7200 {
+		t.Errorf("Expected 2 pending pods waiting 7200s in status, got %d and %d", status.PendingPods, status.MaxWaitSeconds)
+	}
+}
+
+func TestAgingWithoutGuarantee(t *testing.T) {
+	resetQueues()
+	rootQueue.Config.Policy = PolicyFair
+	rootQueue.Config.AgingRate = 10
+	t.Cleanup(func() {
+		rootQueue.Config.Policy = PolicyFIFO
+		rootQueue.Config.AgingRate = 0
+	})
+	CreateQueue("", "root.team", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	CreateQueue("", "root.adhoc", QueueConfig{MaxCapacity: 100})
+	team, adhoc := GetQueue("root.team"), GetQueue("root.adhoc")
+	clusterTotal := v1.ResourceList{v1.ResourceCPU: resourceMustParse("100")}
+	// team uses 20% of its guarantee, adhoc has none and uses 40% of root
+	team.ResourceUsage = v1.ResourceList{v1.ResourceCPU: resourceMustParse("10")}
+	adhoc.ResourceUsage = v1.ResourceList{v1.ResourceCPU: resourceMustParse("40")}
+	now := time.Now()
+	teamPod := pendingPodInQueue("team-1", "root.team", "1")
+	teamPod.CreationTimestamp = metav1.NewTime(now)
+	Enqueue(teamPod)
+	adhocPod := pendingPodInQueue("adhoc-1", "root.adhoc", "1")
+	adhocPod.CreationTimestamp = metav1.NewTime(now)
+	Enqueue(adhocPod)
+
+	if leaf := SelectLeafQueue(clusterTotal, nil); leaf != team {
+		t.Errorf("Expected team below its share first, got %s", leaf.Path)
+	}
+	// 10% per minute for 3 minutes brings adhoc's 0.4 below team's 0.2
+	adhoc.Pods.Delete(adhocPod)
+	adhocPod.CreationTimestamp = metav1.NewTime(now.Add(-3 * time.Minute))
+	Enqueue(adhocPod)
+	if leaf := SelectLeafQueue(clusterTotal, nil); leaf != adhoc {
+		t.Errorf("Expected adhoc without a guarantee to be served after waiting, got %s", leaf.Path)
+	}
+}
+
+func TestLookahead(t *testing.T) {
+	resetQueues()
+	CreateQueue("", "root.mixed", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	mixed := GetQueue("root.mixed")
+	node := readyNode("node1", nil)
+	node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("10"), v1.ResourcePods: resourceMustParse("20")}
+	// big fits the queue, just not next to the 4 cpu already running
+	pods := []*v1.Pod{pendingPodInQueue("big", "root.mixed", "8")}
+	for i := 0; i < 3; i++ {
+		pods = append(pods, pendingPodInQueue(fmt.Sprintf("small-%d", i), "root.mixed", "1"))
+	}
+	objects := []runtime.Object{node, runningPodInQueue("running", "root.mixed", "4", time.Hour)}
+	for _, pod := range pods {
+		objects = append(objects, pod)
+	}
+	config := &rest.Config{Host: "http://127.0.0.1:1"}
+	boundPods := func(clientset *fake.Clientset) []string {
+		var bound []string
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "create" && action.GetSubresource() == "binding" {
+				bound = append(bound, action.(k8stesting.CreateAction).GetObject().(*v1.Binding).Name)
+			}
+		}
+		return bound
+	}
+
+	// Without lookahead the big head pod blocks the whole queue
+	clientset := fake.NewSimpleClientset(objects...)
+	ScheduleCycle(clientset, config, pods)
+	if bound := boundPods(clientset); len(bound) != 0 {
+		t.Fatalf("Expected no pod bound behind the blocked head, got %v", bound)
+	}
+
+	mixed.Config.Lookahead = 2
+	ScheduleCycle(clientset, config, pods)
+	if bound := strings.Join(boundPods(clientset), ","); bound != "small-0,small-1,small-2" {
+		t.Errorf("Expected the small pods to skip ahead of big, got %s", bound)
+	}
+	if head := mixed.Pods.Peek(); head == nil || head.Name != "big" {
+		t.Errorf("Expected big to stay at the head, got %v", head)
+	}
+
+	// Once the head has been bypassed for too long nothing overtakes it
+	mixed.Config.MaxHeadBypassSeconds = 60
+	mixed.headBlockedSince = time.Now().Add(-2 * time.Minute)
+	late := pendingPodInQueue("small-late", "root.mixed", "1")
+	clientset.Tracker().Add(late)
+	ScheduleCycle(clientset, config, []*v1.Pod{pods[0], late})
+	if bound := boundPods(clientset); len(bound) != 3 {
+		t.Errorf("Expected small-late to wait behind big, got %v", bound)
+	}
+
+	// A head above its queue's limit could never run, even past the bypass
+	// time: it is failed with a reason instead of freezing the queue
+	CreateQueue("", "root.batch", QueueConfig{Capacity: 50, MaxCapacity: 100, Lookahead: 2, MaxHeadBypassSeconds: 60})
+	batch := GetQueue("root.batch")
+	huge := pendingPodInQueue("huge", "root.batch", "64")
+	after := pendingPodInQueue("after", "root.batch", "1")
+	clientset = fake.NewSimpleClientset(node, huge, after)
+	Enqueue(huge)
+	batch.headBlockedPod = podKey(huge)
+	batch.headBlockedSince = time.Now().Add(-2 * time.Minute)
+	ScheduleCycle(clientset, config, []*v1.Pod{huge, after})
+	if bound := strings.Join(boundPods(clientset), ","); bound != "after" {
+		t.Errorf("Expected after to be scheduled behind huge, got %s", bound)
+	}
+	failed := false
+	for _, action := range clientset.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok && patch.GetName() == "huge" && patch.GetSubresource() == "status" {
+			failed = strings.Contains(string(patch.GetPatch()), "maximum capacity of queue root.batch")
+		}
+	}
+	if !failed {
+		t.Error("Expected huge to be marked unschedulable with the queue limit as reason")
+	}
+}
+
+func TestBlockedHeadScheduledAfterRelease(t *testing.T) {
+	resetQueues()
+	CreateQueue("", "root.mixed", QueueConfig{Capacity: 100, MaxCapacity: 100, Lookahead: 2, MaxHeadBypassSeconds: 60})
+	mixed := GetQueue("root.mixed")
+	node := readyNode("node1", nil)
+	node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("10"), v1.ResourcePods: resourceMustParse("20")}
+	objects := []runtime.Object{node}
+	for i := 0; i < 6; i++ {
+		objects = append(objects, runningPodInQueue(fmt.Sprintf("running-%d", i), "root.mixed", "1", time.Hour))
+	}
+	big := pendingPodInQueue("big", "root.mixed", "6")
+	small := pendingPodInQueue("small", "root.mixed", "1")
+	objects = append(objects, big, small)
+	clientset := fake.NewSimpleClientset(objects...)
+	config := &rest.Config{Host: "http://127.0.0.1:1"}
+	isBound := func(name string) bool {
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "create" && action.GetSubresource() == "binding" &&
+				action.(k8stesting.CreateAction).GetObject().(*v1.Binding).Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	// 6 of 10 cpu are used: big doesn't fit and small skips ahead of it
+	ScheduleCycle(clientset, config, []*v1.Pod{big, small})
+	if isBound("big") || !isBound("small") {
+		t.Fatalf("Expected only small to skip ahead of big")
+	}
+	small.Spec.NodeName = "node1"
+	small.Spec.SchedulerName = SchedulerName
+	clientset.Tracker().Update(v1.SchemeGroupVersion.WithResource("pods"), small, small.Namespace)
+
+	// Past MaxHeadBypassSeconds nothing overtakes big any more
+	mixed.headBlockedSince = time.Now().Add(-2 * time.Minute)
+	late := pendingPodInQueue("late", "root.mixed", "1")
+	clientset.Tracker().Add(late)
+	ScheduleCycle(clientset, config, []*v1.Pod{big, late})
+	if isBound("big") || isBound("late") {
+		t.Fatalf("Expected big and late to wait while capacity is used")
+	}
+
+	// Pods finishing release their capacity, so big fits in the next cycle
+	for i := 0; i < 3; i++ {
+		clientset.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), "default", fmt.Sprintf("running-%d", i))
+	}
+	ScheduleCycle(clientset, config, []*v1.Pod{big, late})
+	if !isBound("big") {
+		t.Errorf("Expected big to be scheduled once capacity was released")
+	}
+	if isBound("late") {
+		t.Error("Expected late to wait, big used the released capacity")
+	}
+	if mixed.headBlockedPod != podKey(late) {
+		t.Errorf("Expected late to be the blocked head now, got %s", mixed.headBlockedPod)
+	}
+}
+
+func TestElasticBorrowing(t *testing.T) {
+	resetQueues()
+	// Three teams with a 30% guarantee each that may grow to 60%
+	for _, team := range []string{"a", "b", "c"} {
+		CreateQueue("", "root.team-"+team, QueueConfig{Capacity: 30, MaxCapacity: 60})
+	}
+	clusterTotal := v1.ResourceList{v1.ResourceCPU: resourceMustParse("10")}
+	cpu := func(value string) v1.ResourceList { return v1.ResourceList{v1.ResourceCPU: resourceMustParse(value)} }
+	teamA, teamB, teamC := GetQueue("root.team-a"), GetQueue("root.team-b"), GetQueue("root.team-c")
+
+	if limit := getQueueLimit(teamA, clusterTotal)[v1.ResourceCPU]; limit.MilliValue() != 6000 {
+		t.Errorf("Expected team-a limit of 6 cpu, got %s", limit.String())
+	}
+	// team-a borrows the idle capacity of its siblings up to its maximum
+	if !fitsQueueHierarchy(teamA, cpu("5"), clusterTotal) {
+		t.Errorf("Expected team-a to borrow above its 3 cpu guarantee")
+	}
+	if fitsQueueHierarchy(teamA, cpu("7"), clusterTotal) {
+		t.Errorf("Expected team-a to be capped at its 6 cpu maximum")
+	}
+	// Nothing can be borrowed once the siblings use the rest of the cluster
+	teamB.ResourceUsage = cpu("3")
+	teamC.ResourceUsage = cpu("3")
+	teamA.ResourceUsage = cpu("3")
+	if fitsQueueHierarchy(teamA, cpu("2"), clusterTotal) {
+		t.Errorf("Expected no idle capacity left to borrow")
+	}
+	if !fitsQueueHierarchy(teamA, cpu("1"), clusterTotal) {
+		t.Errorf("Expected the last idle cpu to be borrowable")
+	}
+
+	// Without MaxCapacity a queue stays within its guarantee
+	CreateQueue("", "root.fixed", QueueConfig{Capacity: 10})
+	if !fitsQueueHierarchy(GetQueue("root.fixed"), cpu("2"), v1.ResourceList{v1.ResourceCPU: resourceMustParse("100")}) {
+		t.Errorf("Expected 2 cpu to fit the 10 cpu guarantee of fixed")
+	}
+	if limit := getQueueLimit(GetQueue("root.fixed"), clusterTotal)[v1.ResourceCPU]; limit.MilliValue() != 1000 {
+		t.Errorf("Expected fixed to be capped at its capacity, got %s", limit.String())
+	}
+
+	teamA.ResourceUsage = cpu("4500m")
+	status := buildQueueStatus(teamA, clusterTotal)
+	if status.Borrowed["cpu"] != "1500m" || status.Allocated["cpu"] != "4500m" {
+		t.Errorf("Expected 1500m of 4500m cpu borrowed, got %v of %v", status.Borrowed, status.Allocated)
+	}
+}
+
+func TestQueueStatusStopsBorrowing(t *testing.T) {
+	resetQueues()
+	CreateQueue("team-a", "root.team-a", QueueConfig{Capacity: 20, MaxCapacity: 100})
+	// Fake API server that applies status patches to a stored Queue object
+	stored := []byte(`{"apiVersion":"kubescheduler.example.com/v1","kind":"Queue","metadata":{"name":"team-a"}}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/apis/kubescheduler.example.com/v1/queues/team-a/status" {
+			http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		patched, err := patch.Apply(stored)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		stored = patched
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(stored)
+	}))
+	defer server.Close()
+	config := &rest.Config{Host: server.URL}
+	storedStatus := func() map[string]interface{} {
+		var obj map[string]interface{}
+		json.Unmarshal(stored, &obj)
+		status, _ := obj["status"].(map[string]interface{})
+		return status
+	}
+
+	node := readyNode("node1", nil)
+	node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("10")}
+	objects := []runtime.Object{node}
+	for i := 0; i < 4; i++ {
+		objects = append(objects, runningPodInQueue(fmt.Sprintf("a-%d", i), "root.team-a", "1", time.Hour))
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+
+	// 4 cpu used with 2 guaranteed: the status shows 2 borrowed
+	ScheduleCycle(clientset, config, nil)
+	status := storedStatus()
+	if borrowed, _ := status["borrowed"].(map[string]interface{}); borrowed["cpu"] != "2" {
+		t.Fatalf("Expected 2 cpu borrowed in the stored status, got %v", status)
+	}
+
+	// Pods finishing rewrite the status, with nothing left borrowed
+	for i := 0; i < 3; i++ {
+		clientset.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), "default", fmt.Sprintf("a-%d", i))
+	}
+	ScheduleCycle(clientset, config, nil)
+	status = storedStatus()
+	if borrowed, ok := status["borrowed"].(map[string]interface{}); !ok || len(borrowed) != 0 {
+		t.Errorf("Expected no borrowed resources left in the stored status, got %v", status)
+	}
+	if allocated, _ := status["allocated"].(map[string]interface{}); allocated["cpu"] != "1" {
+		t.Errorf("Expected 1 cpu allocated in the stored status, got %v", status)
+	}
+}
+
+func TestCapacityReclaim(t *testing.T) {
+	resetQueues()
+	CreateQueue("", "root.team-a", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	CreateQueue("", "root.team-b", QueueConfig{Capacity: 50, MaxCapacity: 100, ReclaimGracePeriodSeconds: 30})
+	node := readyNode("node1", nil)
+	node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("10")}
+
+	// team-b runs 8 cpu while team-a is idle, borrowing 3 cpu of team-a's guarantee
+	clientset := fake.NewSimpleClientset(node,
+		runningPodInQueue("b-old", "root.team-b", "3", 10*time.Minute),
+		runningPodInQueue("b-new", "root.team-b", "3", time.Minute),
+		runningPodInQueue("b-mid", "root.team-b", "2", 5*time.Minute),
+	)
+	teamB := GetQueue("root.team-b")
+
+	// team-a's 4 cpu pod is within its guarantee, so borrowed capacity is taken back
+	pod := pendingPodInQueue("a-1", "root.team-a", "4")
+	ScheduleCycle(clientset, &rest.Config{Host: "http://127.0.0.1:1"}, []*v1.Pod{pod})
+
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() != "create" || action.GetSubresource() != "eviction" {
+			continue
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		if eviction.DeleteOptions == nil || *eviction.DeleteOptions.GracePeriodSeconds != 30 {
+			t.Errorf("Expected a 30s grace period for %s, got %v", eviction.Name, eviction.DeleteOptions)
+		}
+	}
+	// Evicting the most recently started pod is enough for a-1 to fit
+	if evicted := evictedPods(clientset); len(evicted) != 1 || evicted[0] != "b-new" {
+		t.Errorf("Expected only b-new to be evicted, got %v", evicted)
+	}
+	if usage := teamB.ResourceUsage[v1.ResourceCPU]; usage.Cmp(resourceMustParse("5")) != 0 {
+		t.Errorf("Expected team-b usage of 5 cpu after eviction, got %s", usage.String())
+	}
+	// a-1 waits for b-new to release its node
+	if GetQueue("root.team-a").Pods.Peek() != pod {
+		t.Errorf("Expected a-1 to stay at the head of team-a")
+	}
+
+	// Once b-new is gone and team-a uses its guarantee, team-a can only borrow
+	// and nothing is reclaimed
+	clientset.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), "default", "b-new")
+	clientset.Tracker().Add(runningPodInQueue("a-0", "root.team-a", "5", time.Minute))
+	clientset.ClearActions()
+	ScheduleCycle(clientset, &rest.Config{Host: "http://127.0.0.1:1"}, []*v1.Pod{pendingPodInQueue("a-2", "root.team-a", "4")})
+	if evicted := evictedPods(clientset); len(evicted) != 0 {
+		t.Errorf("Unexpected evictions %v", evicted)
+	}
+	if usage := teamB.ResourceUsage[v1.ResourceCPU]; usage.Cmp(resourceMustParse("5")) != 0 {
+		t.Errorf("Expected team-b usage of 5 cpu from its running pods, got %s", usage.String())
+	}
+}
+
+func TestReclaimedCapacityNotRefilled(t *testing.T) {
+	resetQueues()
+	CreateQueue("", "root.team-a", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	CreateQueue("", "root.team-b", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	node := readyNode("node1", nil)
+	node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("10")}
+	clientset := fake.NewSimpleClientset(node,
+		runningPodInQueue("b-old", "root.team-b", "3", 10*time.Minute),
+		runningPodInQueue("b-new", "root.team-b", "3", time.Minute),
+		runningPodInQueue("b-mid", "root.team-b", "2", 5*time.Minute),
+	)
+	config := &rest.Config{Host: "http://127.0.0.1:1"}
+	teamB := GetQueue("root.team-b")
+	// b-pend has waited longest, so it would be picked first
+	bPend := pendingPodInQueue("b-pend", "root.team-b", "3")
+	bPend.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	aPod := pendingPodInQueue("a-1", "root.team-a", "4")
+	aPod.CreationTimestamp = metav1.NewTime(time.Now())
+	clientset.Tracker().Add(bPend)
+	clientset.Tracker().Add(aPod)
+	isBound := func(name string) bool {
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "create" && action.GetSubresource() == "binding" &&
+				action.(k8stesting.CreateAction).GetObject().(*v1.Binding).Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	ScheduleCycle(clientset, config, []*v1.Pod{bPend, aPod})
+	if evicted := evictedPods(clientset); len(evicted) != 1 || evicted[0] != "b-new" {
+		t.Fatalf("Expected b-new to be evicted, got %v", evicted)
+	}
+	// The evicted capacity is held for a-1, team-b can't take it back
+	clusterTotal := v1.ResourceList{v1.ResourceCPU: resourceMustParse("10")}
+	if fitsQueueHierarchy(teamB, getPodResourceRequests(bPend), clusterTotal) {
+		t.Errorf("Expected b-pend not to fit the capacity reclaimed for a-1")
+	}
+
+	// b-new is gone: a-1 gets the room, b-pend keeps waiting
+	clientset.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), "default", "b-new")
+	ScheduleCycle(clientset, config, []*v1.Pod{bPend, aPod})
+	if !isBound("a-1") || isBound("b-pend") {
+		t.Errorf("Expected a-1 to be bound and b-pend to wait, got a-1 %v and b-pend %v", isBound("a-1"), isBound("b-pend"))
+	}
+	if GetQueue("root.team-a").reservedFor != "" {
+		t.Errorf("Expected the reservation to be released once a-1 was bound")
+	}
+}
+
+func TestReclaimOnlyFromBlockingQueue(t *testing.T) {
+	resetQueues()
+	// org is capped at 2 of the 10 cpu, root.x borrows 7 cpu with no guarantee
+	CreateQueue("", "root.org", QueueConfig{Capacity: 20, MaxCapacity: 20})
+	CreateQueue("", "root.org.a", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	CreateQueue("", "root.org.b", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	CreateQueue("", "root.x", QueueConfig{Capacity: 0, MaxCapacity: 100})
+	node := readyNode("node1", nil)
+	node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("10")}
+	objects := []runtime.Object{node, runningPodInQueue("a-1", "root.org.a", "2", time.Hour)}
+	for i := 0; i < 7; i++ {
+		objects = append(objects, runningPodInQueue(fmt.Sprintf("x-%d", i), "root.x", "1", time.Duration(i)*time.Second))
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+
+	// root has room, org doesn't: only org.a's pod frees org, however new root.x's pods are
+	ScheduleCycle(clientset, &rest.Config{Host: "http://127.0.0.1:1"}, []*v1.Pod{pendingPodInQueue("b-1", "root.org.b", "1")})
+	if evicted := evictedPods(clientset); len(evicted) != 1 || evicted[0] != "a-1" {
+		t.Errorf("Expected only a-1 to be evicted, got %v", evicted)
+	}
+
+	// With org.a back within an overcommitted guarantee, nothing below org
+	// borrows: evicting root.x's pods wouldn't help, so none are evicted
+	resetQueues()
+	CreateQueue("", "root.org", QueueConfig{Capacity: 20, MaxCapacity: 20})
+	CreateQueue("", "root.org.a", QueueConfig{Capacity: 60, MaxCapacity: 100})
+	CreateQueue("", "root.org.b", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	CreateQueue("", "root.x", QueueConfig{Capacity: 0, MaxCapacity: 100})
+	objects[1] = runningPodInQueue("a-1", "root.org.a", "1200m", time.Hour)
+	clientset = fake.NewSimpleClientset(objects...)
+	ScheduleCycle(clientset, &rest.Config{Host: "http://127.0.0.1:1"}, []*v1.Pod{pendingPodInQueue("b-1", "root.org.b", "1")})
+	if evicted := evictedPods(clientset); len(evicted) != 0 {
+		t.Errorf("Expected no evictions, got %v", evicted)
+	}
+}
+
+func TestGuaranteeReclaimedFromImplicitQueue(t *testing.T) {
+	resetQueues()
+	CreateQueue("", "root.team", QueueConfig{Capacity: 50, MaxCapacity: 100})
+	node := readyNode("node1", nil)
+	node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("10"), v1.ResourcePods: resourceMustParse("20")}
+	objects := []runtime.Object{node}
+	for i := 0; i < 10; i++ {
+		pod := runningPodInQueue(fmt.Sprintf("scratch-%d", i), "", "1", time.Duration(i)*time.Second)
+		pod.Namespace = "scratch"
+		delete(pod.Annotations, "scheduler.kubernetes.io/queue")
+		objects = append(objects, pod)
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	config := &rest.Config{Host: "http://127.0.0.1:1"}
+
+	// An unlabelled namespace took the whole node through its implicit queue,
+	// which has no guarantee, so all of it is borrowed and reclaimable
+	head := pendingPodInQueue("team-1", "root.team", "3")
+	clientset.Tracker().Add(head)
+	ScheduleCycle(clientset, config, []*v1.Pod{head})
+	evicted := evictedPods(clientset)
+	if strings.Join(evicted, ",") != "scratch-0,scratch-1,scratch-2" {
+		t.Fatalf("Expected the three newest scratch pods to be evicted, got %v", evicted)
+	}
+
+	// Once they are gone team gets its share back
+	for _, name := range evicted {
+		clientset.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), "scratch", name)
+	}
+	ScheduleCycle(clientset, config, []*v1.Pod{head})
+	bound := false
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "create" && action.GetSubresource() == "binding" &&
+			action.(k8stesting.CreateAction).GetObject().(*v1.Binding).Name == "team-1" {
+			bound = true
+		}
+	}
+	if !bound {
+		t.Error("Expected team-1 to be bound after reclaiming its guarantee")
+	}
+}
+
+// Helper for test: pod of the queue bound to node1 that started a while ago
+func runningPodInQueue(name, queuePath, cpu string, startedAgo time.Duration) *v1.Pod {
+	pod := pendingPodInQueue(name, queuePath, cpu)
+	pod.Spec.SchedulerName = SchedulerName
+	pod.Spec.NodeName = "node1"
+	pod.Status.Phase = v1.PodRunning
+	started := metav1.NewTime(time.Now().Add(-startedAgo))
+	pod.Status.StartTime = &started
+	return pod
+}
+
+// Helper for test: names of the pods evicted through the fake clientset, in order
+func evictedPods(clientset *fake.Clientset) []string {
+	var evicted []string
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "create" && action.GetSubresource() == "eviction" {
+			evicted = append(evicted, action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction).Name)
+		}
+	}
+	return evicted
+}
+
+func TestAbsoluteQuotas(t *testing.T) {
+	resetQueues()
+	gpu := v1.ResourceName("nvidia.com/gpu")
+	clusterTotal := v1.ResourceList{
+		v1.ResourceCPU:    resourceMustParse("100"),
+		v1.ResourceMemory: resourceMustParse("512Gi"),
+		gpu:               resourceMustParse("8"),
+	}
+
+	// Fractional percentages are kept: 50% of 15% is 7.5%
+	CreateQueue("", "root.research", QueueConfig{Capacity: 15})
+	CreateQueue("", "root.research.nlp", QueueConfig{Capacity: 50})
+	if cpu := getQueueGuarantee(GetQueue("root.research.nlp"), clusterTotal)[v1.ResourceCPU]; cpu.MilliValue() != 7500 {
+		t.Errorf("Expected 7500m cpu guaranteed to nlp, got %s", cpu.String())
+	}
+
+	UpdateQueueState(&unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "finance"},
+		"spec": map[string]interface{}{
+			"path":     "root.finance",
+			"capacity": 12.5,
+			"resources": map[string]interface{}{
+				"guaranteed": map[string]interface{}{"cpu": int64(40), "memory": "128Gi", "nvidia.com/gpu": int64(4)},
+				"max":        map[string]interface{}{"cpu": int64(60), "memory": "bad"},
+			},
+		},
+	}})
+	finance := GetQueue("root.finance")
+	if finance.Config.Capacity != 12.5 {
+		t.Errorf("Expected capacity 12.5, got %g", finance.Config.Capacity)
+	}
+	guarantee := getQueueGuarantee(finance, clusterTotal)
+	limit := getQueueLimit(finance, clusterTotal)
+	expect := func(what string, got resource.Quantity, want string) {
+		if got.Cmp(resourceMustParse(want)) != 0 {
+			t.Errorf("Expected %s %s, got %s", what, want, got.String())
+		}
+	}
+	expect("guaranteed cpu", guarantee[v1.ResourceCPU], "40")
+	expect("guaranteed memory", guarantee[v1.ResourceMemory], "128Gi")
+	expect("guaranteed gpu", guarantee[gpu], "4")
+	expect("max cpu", limit[v1.ResourceCPU], "60")
+	// Without MaxCapacity an absolute guarantee is also the limit
+	expect("max memory", limit[v1.ResourceMemory], "128Gi")
+	expect("max gpu", limit[gpu], "4")
+
+	// Children of a queue with absolute quotas get percentages of them
+	CreateQueue("", "root.finance.reporting", QueueConfig{Capacity: 50})
+	reporting := getQueueGuarantee(GetQueue("root.finance.reporting"), clusterTotal)
+	expect("reporting cpu", reporting[v1.ResourceCPU], "20")
+	expect("reporting gpu", reporting[gpu], "2")
+	if !fitsQueueHierarchy(finance, v1.ResourceList{v1.ResourceCPU: resourceMustParse("55")}, clusterTotal) {
+		t.Errorf("Expected finance to borrow up to 60 cpu")
+	}
+	if fitsQueueHierarchy(finance, v1.ResourceList{gpu: resourceMustParse("5")}, clusterTotal) {
+		t.Errorf("Expected finance to be capped at 4 gpus")
+	}
+}
+
+func TestPerResourceCapacity(t *testing.T) {
+	resetQueues()
+	clusterTotal := v1.ResourceList{
+		v1.ResourceCPU:    resourceMustParse("100"),
+		v1.ResourceMemory: resourceMustParse("100Gi"),
+	}
+	// A memory-heavy share: 30% of cpu but 60% of memory, growing to 80% of memory
+	UpdateQueueState(&unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "analytics"},
+		"spec": map[string]interface{}{
+			"path":     "root.analytics",
+			"capacity": int64(30),
+			"resources": map[string]interface{}{
+				"capacity":    map[string]interface{}{"memory": int64(60)},
+				"maxCapacity": map[string]interface{}{"memory": 80.5},
+			},
+		},
+	}})
+	analytics := GetQueue("root.analytics")
+	request := func(cpu, memory string) v1.ResourceList {
+		return v1.ResourceList{v1.ResourceCPU: resourceMustParse(cpu), v1.ResourceMemory: resourceMustParse(memory)}
+	}
+	if !isWithinCapacity(request("30", "60Gi"), clusterTotal, analytics) {
+		t.Errorf("Expected 30 cpu and 60Gi memory to fit the guarantee of analytics")
+	}
+	if isWithinCapacity(request("31", "10Gi"), clusterTotal, analytics) {
+		t.Errorf("Expected 31 cpu to exceed the 30%% cpu guarantee")
+	}
+	if isWithinCapacity(request("10", "61Gi"), clusterTotal, analytics) {
+		t.Errorf("Expected 61Gi memory to exceed the 60%% memory guarantee")
+	}
+	// Only memory has a maximum above the guarantee
+	if !isWithinMaxCapacity(request("30", "80Gi"), clusterTotal, analytics) {
+		t.Errorf("Expected analytics to borrow memory up to 80.5%%")
+	}
+	if isWithinMaxCapacity(request("31", "10Gi"), clusterTotal, analytics) {
+		t.Errorf("Expected analytics cpu to be capped at its guarantee")
+	}
+
+	// Children apply their percentages to each resource of the parent
+	CreateQueue("", "root.analytics.adhoc", QueueConfig{Capacity: 50})
+	guarantee := getQueueGuarantee(GetQueue("root.analytics.adhoc"), clusterTotal)
+	if cpu, memory := guarantee[v1.ResourceCPU], guarantee[v1.ResourceMemory]; cpu.Cmp(resourceMustParse("15")) != 0 || memory.Cmp(resourceMustParse("30Gi")) != 0 {
+		t.Errorf("Expected 15 cpu and 30Gi memory guaranteed to adhoc, got %s and %s", cpu.String(), memory.String())
+	}
+}
+
 func TestHierarchicalQueueCapacity(t *testing.T) {
 	// Reset rootQueue for test isolation
 	rootQueue.Children = make(map[string]*Queue)
 
 	// Create a hierarchy: root (100%) -> teamA (50%) -> subteam1 (20%)
-	CreateQueue("root.teamA", QueueConfig{Capacity: 50, MaxCapacity: 100, Policy: "fifo"})
-	CreateQueue("root.teamA.subteam1", QueueConfig{Capacity: 20, MaxCapacity: 100, Policy: "fifo"})
+	CreateQueue("", "root.teamA", QueueConfig{Capacity: 50, MaxCapacity: 100, Policy: "fifo"})
+	CreateQueue("", "root.teamA.subteam1", QueueConfig{Capacity: 20, MaxCapacity: 100, Policy: "fifo"})
 
 	// Simulate a cluster with 1000m CPU and 2Gi memory
 	clusterResources := v1.ResourceList{
@@ -133,9 +1498,8 @@ func TestHierarchicalQueueCapacity(t *testing.T) {
 	if q == nil {
 		t.Fatal("subteam1 queue not found")
 	}
-	percent := getEffectiveCapacityPercent(q)
-	if percent != 10 {
-		t.Errorf("Expected effective percent 10, got %d", percent)
+	if guarantee := getQueueGuarantee(q, clusterResources)[v1.ResourceCPU]; guarantee.MilliValue() != 100 {
+		t.Errorf("Expected effective capacity of 100m cpu, got %s", guarantee.String())
 	}
 
 	// Pod requesting 200m CPU, 256Mi memory (should NOT fit, 200m > 100m allowed)
@@ -195,6 +1559,163 @@ func TestHierarchicalQueueCapacity(t *testing.T) {
 	}
 }
 
+func TestGetPodResourceRequests(t *testing.T) {
+	always := v1.ContainerRestartPolicyAlways
+	container := func(cpu, memory string) v1.Container {
+		return v1.Container{Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+			v1.ResourceCPU:    resourceMustParse(cpu),
+			v1.ResourceMemory: resourceMustParse(memory),
+		}}}
+	}
+	sidecar := container("200m", "64Mi")
+	sidecar.RestartPolicy = &always
+
+	tests := []struct {
+		name       string
+		spec       v1.PodSpec
+		wantCPU    string
+		wantMemory string
+	}{
+		{
+			name:       "app containers are summed",
+			spec:       v1.PodSpec{Containers: []v1.Container{container("100m", "128Mi"), container("200m", "128Mi")}},
+			wantCPU:    "300m",
+			wantMemory: "256Mi",
+		},
+		{
+			name: "heavy init container dominates",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{container("2", "64Mi"), container("500m", "1Gi")},
+				Containers:     []v1.Container{container("100m", "128Mi")},
+			},
+			wantCPU:    "2",
+			wantMemory: "1Gi",
+		},
+		{
+			name: "sidecars run next to app and later init containers",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{sidecar, container("1", "64Mi")},
+				Containers:     []v1.Container{container("100m", "128Mi")},
+			},
+			// max(100m + 200m, 1 + 200m) and max(128Mi + 64Mi, 64Mi + 64Mi)
+			wantCPU:    "1200m",
+			wantMemory: "192Mi",
+		},
+		{
+			name: "overhead is added",
+			spec: v1.PodSpec{
+				Containers: []v1.Container{container("100m", "128Mi")},
+				Overhead: v1.ResourceList{
+					v1.ResourceCPU:    resourceMustParse("250m"),
+					v1.ResourceMemory: resourceMustParse("120Mi"),
+				},
+			},
+			wantCPU:    "350m",
+			wantMemory: "248Mi",
+		},
+		{
+			name: "pod-level requests replace container totals",
+			spec: v1.PodSpec{
+				Containers: []v1.Container{container("100m", "128Mi"), {}},
+				Resources: &v1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceCPU: resourceMustParse("1"),
+				}},
+			},
+			wantCPU:    "1",
+			wantMemory: "128Mi",
+		},
+	}
+	for _, tt := range tests {
+		got := getPodResourceRequests(&v1.Pod{Spec: tt.spec})
+		if cpu := got[v1.ResourceCPU]; cpu.Cmp(resourceMustParse(tt.wantCPU)) != 0 {
+			t.Errorf("%s: expected cpu %s, got %s", tt.name, tt.wantCPU, cpu.String())
+		}
+		if memory := got[v1.ResourceMemory]; memory.Cmp(resourceMustParse(tt.wantMemory)) != 0 {
+			t.Errorf("%s: expected memory %s, got %s", tt.name, tt.wantMemory, memory.String())
+		}
+	}
+}
+
+func TestExtendedResources(t *testing.T) {
+	gpu := v1.ResourceName("nvidia.com/gpu")
+	rootQueue.Children = make(map[string]*Queue)
+	CreateQueue("", "root.ml", QueueConfig{Capacity: 50, MaxCapacity: 100, Policy: "fifo"})
+	CreateQueue("", "root.small", QueueConfig{Capacity: 10, MaxCapacity: 100, Policy: "fifo"})
+
+	gpuNode := readyNode("gpu-node", nil)
+	gpuNode.Status.Allocatable = v1.ResourceList{
+		v1.ResourceCPU: resourceMustParse("8"),
+		gpu:            resourceMustParse("4"),
+	}
+	cpuNode := readyNode("cpu-node", nil)
+	cpuNode.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resourceMustParse("8")}
+	clientset := fake.NewSimpleClientset(cpuNode, gpuNode)
+
+	clusterTotal, err := GetClusterTotalResources(clientset)
+	if err != nil {
+		t.Fatalf("Failed to get cluster resources: %v", err)
+	}
+	gpus := func(n string) v1.ResourceList { return v1.ResourceList{gpu: resourceMustParse(n)} }
+
+	// 50% of 4 GPUs is 2 whole GPUs
+	if !isWithinCapacity(gpus("2"), clusterTotal, GetQueue("root.ml")) {
+		t.Error("2 GPUs should fit within 50% of 4 GPUs")
+	}
+	if isWithinCapacity(gpus("3"), clusterTotal, GetQueue("root.ml")) {
+		t.Error("3 GPUs should not fit within 50% of 4 GPUs")
+	}
+	// 10% of 4 GPUs rounds down to no GPU at all
+	if isWithinCapacity(gpus("1"), clusterTotal, GetQueue("root.small")) {
+		t.Error("1 GPU should not fit within 10% of 4 GPUs")
+	}
+	// Resources the cluster does not have never fit
+	if isWithinCapacity(v1.ResourceList{"example.com/foo": resourceMustParse("1")}, clusterTotal, GetQueue("root.ml")) {
+		t.Error("Extended resource missing from the cluster should not fit")
+	}
+
+	node, err := SelectBestNode(clientset, podWithRequests(gpus("1")))
+	if err != nil || node != "gpu-node" {
+		t.Errorf("Expected gpu-node for GPU pod, got %q (err: %v)", node, err)
+	}
+	if _, err := SelectBestNode(clientset, podWithRequests(gpus("5"))); err == nil {
+		t.Error("Expected error when no node has 5 GPUs")
+	}
+
+	// Only whole devices can be requested
+	if err := validateExtendedResources(podWithRequests(gpus("500m"))); err == nil {
+		t.Error("Expected error for fractional GPU request")
+	}
+	overcommitted := podWithRequests(gpus("1"))
+	overcommitted.Spec.Containers[0].Resources.Limits = gpus("2")
+	if err := validateExtendedResources(overcommitted); err == nil {
+		t.Error("Expected error for GPU request different from limit")
+	}
+	if _, err := SelectBestNode(clientset, podWithRequests(gpus("500m"))); err == nil {
+		t.Error("Expected SelectBestNode to reject fractional GPU request")
+	}
+
+	q := GetQueue("root.ml")
+	q.ResourceUsage = v1.ResourceList{
+		v1.ResourceCPU: resourceMustParse("4"),
+		gpu:            resourceMustParse("2"),
+	}
+	status := buildQueueStatus(q, clusterTotal)
+	if status.CPUUsage != 25 || status.ResourceUsage["nvidia.com/gpu"] != 50 || status.Allocated["nvidia.com/gpu"] != "2" {
+		t.Errorf("Unexpected queue status: %+v", status)
+	}
+}
+
+// Helper for test: build a single-container pod with the given requests
+func podWithRequests(requests v1.ResourceList) *v1.Pod {
+	return &v1.Pod{
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Resources: v1.ResourceRequirements{Requests: requests},
+			}},
+		},
+	}
+}
+
 // Helper for test: parse resource quantity and panic on error
 func resourceMustParse(s string) resource.Quantity {
 	q, err := resource.ParseQuantity(s)
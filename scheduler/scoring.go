@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"math"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Node scoring strategies a queue can choose with spec.scoringStrategy
+const (
+	// LeastAllocated favors nodes with the most free resources, spreading pods out
+	LeastAllocated = "LeastAllocated"
+	// MostAllocated favors the fullest nodes, packing pods tightly
+	MostAllocated = "MostAllocated"
+	// BalancedAllocation favors nodes whose resources stay evenly used
+	BalancedAllocation = "BalancedAllocation"
+)
+
+// Resources considered when scoring nodes by allocation
+var scoringResources = []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory}
+
+func isValidScoringStrategy(strategy string) bool {
+	switch strategy {
+	case LeastAllocated, MostAllocated, BalancedAllocation:
+		return true
+	}
+	return false
+}
+
+// Helper to get the scoring strategy of the queue the pod belongs to
+func getScoringStrategyForPod(pod *v1.Pod) string {
+	queue := GetQueue(getQueuePathForPod(pod))
+	if queue == nil || queue.Config.ScoringStrategy == "" {
+		return LeastAllocated
+	}
+	return queue.Config.ScoringStrategy
+}
+
+// Score ranks the node according to the scoring strategy of the pod's queue,
+// using the node's requested resources plus the pod's requests
+func (p *NodeResourcesFit) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, error) {
+	requested := addResourceLists(nodeInfo.Requested, getPodResourceRequests(pod))
+	allocatable := nodeInfo.Node.Status.Allocatable
+	switch getScoringStrategyForPod(pod) {
+	case MostAllocated:
+		return mostAllocatedScore(requested, allocatable), nil
+	case BalancedAllocation:
+		return balancedAllocationScore(requested, allocatable), nil
+	default:
+		return leastAllocatedScore(requested, allocatable), nil
+	}
+}
+
+// Helper to get the requested fraction of a resource, capped at 1. ok is false
+// when the node has none of the resource.
+func requestedFraction(name v1.ResourceName, requested, allocatable v1.ResourceList) (float64, bool) {
+	allocQty, found := allocatable[name]
+	if !found || allocQty.IsZero() {
+		return 0, false
+	}
+	reqQty := requested[name]
+	fraction := float64(reqQty.MilliValue()) / float64(allocQty.MilliValue())
+	if fraction > 1 {
+		fraction = 1
+	}
+	return fraction, true
+}
+
+// leastAllocatedScore is the average free fraction of the scored resources
+func leastAllocatedScore(requested, allocatable v1.ResourceList) int64 {
+	var sum float64
+	count := 0
+	for _, name := range scoringResources {
+		fraction, ok := requestedFraction(name, requested, allocatable)
+		if !ok {
+			continue
+		}
+		sum += 1 - fraction
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return int64(sum / float64(count) * float64(MaxNodeScore))
+}
+
+// mostAllocatedScore is the average requested fraction of the scored resources
+func mostAllocatedScore(requested, allocatable v1.ResourceList) int64 {
+	var sum float64
+	count := 0
+	for _, name := range scoringResources {
+		fraction, ok := requestedFraction(name, requested, allocatable)
+		if !ok {
+			continue
+		}
+		sum += fraction
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return int64(sum / float64(count) * float64(MaxNodeScore))
+}
+
+// balancedAllocationScore is (1 - standard deviation of the requested fractions),
+// so nodes whose resources are used evenly score higher
+func balancedAllocationScore(requested, allocatable v1.ResourceList) int64 {
+	fractions := make([]float64, 0, len(scoringResources))
+	for _, name := range scoringResources {
+		if fraction, ok := requestedFraction(name, requested, allocatable); ok {
+			fractions = append(fractions, fraction)
+		}
+	}
+	if len(fractions) == 0 {
+		return 0
+	}
+	var mean float64
+	for _, f := range fractions {
+		mean += f
+	}
+	mean /= float64(len(fractions))
+	var variance float64
+	for _, f := range fractions {
+		variance += (f - mean) * (f - mean)
+	}
+	std := math.Sqrt(variance / float64(len(fractions)))
+	return int64((1 - std) * float64(MaxNodeScore))
+}
@@ -2,7 +2,8 @@ package update_status
 
 import (
     "context"
-    "fmt"
+    "encoding/json"
+
     "k8s.io/apimachinery/pkg/types"
     "k8s.io/client-go/dynamic"
     "k8s.io/client-go/rest"
@@ -10,8 +11,27 @@ import (
     "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// UpdateQueueStatus updates the status.cpuUsage and status.memoryUsage fields of the Queue CRD
-func UpdateQueueStatus(config *rest.Config, queueName string, cpuPercent, memPercent int) error {
+// QueueStatus is the status subresource of the Queue CRD
+type QueueStatus struct {
+    CPUUsage    int `json:"cpuUsage"`    // Percentage of cluster CPU used by the queue
+    MemoryUsage int `json:"memoryUsage"` // Percentage of cluster memory used by the queue
+    // Percentage of the cluster total used by the queue, for every resource
+    // it requests including extended resources such as nvidia.com/gpu
+    ResourceUsage map[string]int `json:"resourceUsage"`
+    // Absolute quantity requested by the queue's pods, per resource
+    Allocated map[string]string `json:"allocated"`
+    // Part of the allocated quantity above the queue's guaranteed capacity,
+    // borrowed from idle siblings up to maxCapacity
+    Borrowed map[string]string `json:"borrowed"`
+    // Pods waiting in the queue and how long the oldest one has waited
+    PendingPods    int   `json:"pendingPods"`
+    MaxWaitSeconds int64 `json:"maxWaitSeconds"`
+}
+
+// UpdateQueueStatus replaces the status of the Queue CRD. A merge patch would
+// keep the old value of every resource left out, so a queue that stopped
+// borrowing would still show what it borrowed last.
+func UpdateQueueStatus(config *rest.Config, queueName string, status QueueStatus) error {
     dynClient, err := dynamic.NewForConfig(config)
     if err != nil {
         return err
@@ -23,11 +43,16 @@ func UpdateQueueStatus(config *rest.Config, queueName string, cpuPercent, memPer
         Resource: "queues",
     }
 
-    patch := []byte(fmt.Sprintf(`{"status":{"cpuUsage":%d, "memoryUsage":%d}}`, cpuPercent, memPercent))
+    patch, err := json.Marshal([]map[string]interface{}{
+        {"op": "add", "path": "/status", "value": status},
+    })
+    if err != nil {
+        return err
+    }
     _, err = dynClient.Resource(queueGVR).Namespace("").Patch(
         context.TODO(),
         queueName,
-        types.MergePatchType,
+        types.JSONPatchType,
         patch,
         v1.PatchOptions{},
         "status",
@@ -0,0 +1,462 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	VolumeBindingName    = "VolumeBinding"
+	NodeVolumeLimitsName = "NodeVolumeLimits"
+
+	volumeSnapshotKey            = "VolumeSnapshot"
+	volumeBindingPreFilterKey    = "PreFilter" + VolumeBindingName
+	nodeVolumeLimitsPreFilterKey = "PreFilter" + NodeVolumeLimitsName
+
+	// Provisioner of storage classes that only bind pre-created (e.g. local) volumes
+	noProvisioner = "kubernetes.io/no-provisioner"
+	// Annotation marking the storage class used by claims without storageClassName
+	defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	// Annotation telling the provisioner on which node a delayed claim is needed
+	selectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+	// Annotation on PVs bound by the scheduler instead of the user
+	boundByControllerAnnotation = "pv.kubernetes.io/bound-by-controller"
+	// Prefix of legacy node allocatable keys that carry CSI attach limits
+	attachableVolumesCSIPrefix = "attachable-volumes-csi-"
+)
+
+// volumeSnapshot holds the storage objects listed once per scheduling cycle
+type volumeSnapshot struct {
+	// Claims keyed by namespace/name
+	pvcs     map[string]*v1.PersistentVolumeClaim
+	pvs      map[string]*v1.PersistentVolume
+	classes  map[string]*storagev1.StorageClass
+	csiNodes map[string]*storagev1.CSINode
+}
+
+// getVolumeSnapshot lists claims, volumes, storage classes and CSINodes once per cycle
+func getVolumeSnapshot(state *CycleState) (*volumeSnapshot, error) {
+	if v, ok := state.Read(volumeSnapshotKey); ok {
+		return v.(*volumeSnapshot), nil
+	}
+	ctx := context.TODO()
+	pvcs, err := state.ClientSet.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pvs, err := state.ClientSet.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	classes, err := state.ClientSet.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	csiNodes, err := state.ClientSet.StorageV1().CSINodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &volumeSnapshot{
+		pvcs:     map[string]*v1.PersistentVolumeClaim{},
+		pvs:      map[string]*v1.PersistentVolume{},
+		classes:  map[string]*storagev1.StorageClass{},
+		csiNodes: map[string]*storagev1.CSINode{},
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		snapshot.pvcs[pvc.Namespace+"/"+pvc.Name] = pvc
+	}
+	for i := range pvs.Items {
+		snapshot.pvs[pvs.Items[i].Name] = &pvs.Items[i]
+	}
+	for i := range classes.Items {
+		snapshot.classes[classes.Items[i].Name] = &classes.Items[i]
+	}
+	for i := range csiNodes.Items {
+		snapshot.csiNodes[csiNodes.Items[i].Name] = &csiNodes.Items[i]
+	}
+	state.Write(volumeSnapshotKey, snapshot)
+	return snapshot, nil
+}
+
+// Helper to get the storage class of a claim, falling back to the default class
+func (s *volumeSnapshot) getClaimClass(pvc *v1.PersistentVolumeClaim) *storagev1.StorageClass {
+	if pvc.Spec.StorageClassName != nil {
+		return s.classes[*pvc.Spec.StorageClassName]
+	}
+	for _, class := range s.classes {
+		if class.Annotations[defaultStorageClassAnnotation] == "true" {
+			return class
+		}
+	}
+	return nil
+}
+
+// Helper to get the claim names of a pod, including generic ephemeral volumes
+func getPodClaimNames(pod *v1.Pod) []string {
+	var names []string
+	for _, vol := range pod.Spec.Volumes {
+		switch {
+		case vol.PersistentVolumeClaim != nil:
+			names = append(names, vol.PersistentVolumeClaim.ClaimName)
+		case vol.Ephemeral != nil:
+			names = append(names, pod.Name+"-"+vol.Name)
+		}
+	}
+	return names
+}
+
+// VolumeBinding filters nodes by the topology of the pod's volumes: node
+// affinity of bound PVs and allowedTopologies or matching local PVs for
+// WaitForFirstConsumer claims. Before the pod is bound it binds those claims
+// for the chosen node.
+type VolumeBinding struct{}
+
+func (p *VolumeBinding) Name() string { return VolumeBindingName }
+
+// volumeBindingState holds the claims of the pod split by binding state
+type volumeBindingState struct {
+	boundPVs []*v1.PersistentVolume
+	// Unbound WaitForFirstConsumer claims with their storage class
+	delayedClaims []*v1.PersistentVolumeClaim
+	delayedClass  map[string]*storagev1.StorageClass
+	snapshot      *volumeSnapshot
+}
+
+func (p *VolumeBinding) PreFilter(state *CycleState, pod *v1.Pod) error {
+	s := &volumeBindingState{delayedClass: map[string]*storagev1.StorageClass{}}
+	claimNames := getPodClaimNames(pod)
+	if len(claimNames) > 0 {
+		snapshot, err := getVolumeSnapshot(state)
+		if err != nil {
+			return err
+		}
+		s.snapshot = snapshot
+		for _, name := range claimNames {
+			pvc, ok := snapshot.pvcs[pod.Namespace+"/"+name]
+			if !ok {
+				return fmt.Errorf("persistentvolumeclaim %q not found", name)
+			}
+			if pvc.DeletionTimestamp != nil {
+				return fmt.Errorf("persistentvolumeclaim %q is being deleted", name)
+			}
+			if pvc.Spec.VolumeName != "" {
+				pv, ok := snapshot.pvs[pvc.Spec.VolumeName]
+				if !ok {
+					return fmt.Errorf("persistentvolume %q bound to claim %q not found", pvc.Spec.VolumeName, name)
+				}
+				s.boundPVs = append(s.boundPVs, pv)
+				continue
+			}
+			class := snapshot.getClaimClass(pvc)
+			if class == nil || class.VolumeBindingMode == nil || *class.VolumeBindingMode != storagev1.VolumeBindingWaitForFirstConsumer {
+				return fmt.Errorf("pod has unbound immediate PersistentVolumeClaims")
+			}
+			s.delayedClaims = append(s.delayedClaims, pvc)
+			s.delayedClass[pvc.Name] = class
+		}
+	}
+	state.Write(volumeBindingPreFilterKey, s)
+	return nil
+}
+
+func (p *VolumeBinding) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	v, ok := state.Read(volumeBindingPreFilterKey)
+	if !ok {
+		return fmt.Errorf("%s prefilter state not found", VolumeBindingName)
+	}
+	s := v.(*volumeBindingState)
+	node := nodeInfo.Node
+
+	for _, pv := range s.boundPVs {
+		if !pvMatchesNode(pv, node) {
+			return fmt.Errorf("node(s) had volume node affinity conflict")
+		}
+	}
+
+	// Each claim needs a volume of its own
+	reserved := map[string]bool{}
+	for _, pvc := range s.delayedClaims {
+		class := s.delayedClass[pvc.Name]
+		if class.Provisioner == noProvisioner {
+			pv := findMatchingPV(s.snapshot, pvc, class, node, reserved)
+			if pv == nil {
+				return fmt.Errorf("node(s) didn't find available persistent volumes to bind")
+			}
+			reserved[pv.Name] = true
+			continue
+		}
+		if len(class.AllowedTopologies) > 0 && !nodeMatchesTopologyTerms(node, class.AllowedTopologies) {
+			return fmt.Errorf("node(s) didn't match storage class allowed topologies")
+		}
+	}
+	return nil
+}
+
+// PreBind binds the pod's WaitForFirstConsumer claims for the chosen node, like
+// upstream: a matching available PV is bound to each claim of a no-provisioner
+// class, and claims to provision get the selected-node annotation so the
+// provisioner creates the volume where the pod runs.
+func (p *VolumeBinding) PreBind(state *CycleState, pod *v1.Pod, nodeName string) error {
+	claimNames := getPodClaimNames(pod)
+	if len(claimNames) == 0 {
+		return nil
+	}
+	ctx := context.TODO()
+	snapshot, err := getVolumeSnapshot(state)
+	if err != nil {
+		return err
+	}
+	var node *v1.Node
+	reserved := map[string]bool{}
+	for _, name := range claimNames {
+		pvc, ok := snapshot.pvcs[pod.Namespace+"/"+name]
+		if !ok {
+			return fmt.Errorf("persistentvolumeclaim %q not found", name)
+		}
+		if pvc.Spec.VolumeName != "" {
+			continue
+		}
+		class := snapshot.getClaimClass(pvc)
+		if class == nil || class.VolumeBindingMode == nil || *class.VolumeBindingMode != storagev1.VolumeBindingWaitForFirstConsumer {
+			continue
+		}
+		if class.Provisioner != noProvisioner {
+			if pvc.Annotations[selectedNodeAnnotation] == nodeName {
+				continue
+			}
+			claim := pvc.DeepCopy()
+			if claim.Annotations == nil {
+				claim.Annotations = map[string]string{}
+			}
+			claim.Annotations[selectedNodeAnnotation] = nodeName
+			if _, err := state.ClientSet.CoreV1().PersistentVolumeClaims(pod.Namespace).Update(ctx, claim, metav1.UpdateOptions{}); err != nil {
+				return fmt.Errorf("failed to select node for persistentvolumeclaim %q: %v", name, err)
+			}
+			continue
+		}
+		if node == nil {
+			node, err = state.ClientSet.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+		}
+		pv := findMatchingPV(snapshot, pvc, class, node, reserved)
+		if pv == nil {
+			return fmt.Errorf("no available persistent volume for claim %q on node %s", name, nodeName)
+		}
+		reserved[pv.Name] = true
+		volume := pv.DeepCopy()
+		volume.Spec.ClaimRef = &v1.ObjectReference{
+			Kind:            "PersistentVolumeClaim",
+			APIVersion:      "v1",
+			Namespace:       pvc.Namespace,
+			Name:            pvc.Name,
+			UID:             pvc.UID,
+			ResourceVersion: pvc.ResourceVersion,
+		}
+		if volume.Annotations == nil {
+			volume.Annotations = map[string]string{}
+		}
+		volume.Annotations[boundByControllerAnnotation] = "yes"
+		if _, err := state.ClientSet.CoreV1().PersistentVolumes().Update(ctx, volume, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to bind persistentvolume %q to claim %q: %v", pv.Name, name, err)
+		}
+		fmt.Printf("Bound persistentvolume %s to claim %s/%s\n", pv.Name, pvc.Namespace, pvc.Name)
+	}
+	return nil
+}
+
+// Helper to check the required node affinity of a PV against the node
+func pvMatchesNode(pv *v1.PersistentVolume, node *v1.Node) bool {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return true
+	}
+	return nodeMatchesNodeSelectorTerms(node, pv.Spec.NodeAffinity.Required.NodeSelectorTerms)
+}
+
+// Helper to check the node labels against StorageClass allowedTopologies.
+// Terms are ORed and the expressions inside a term are ANDed.
+func nodeMatchesTopologyTerms(node *v1.Node, terms []v1.TopologySelectorTerm) bool {
+	for _, term := range terms {
+		matches := true
+		for _, expr := range term.MatchLabelExpressions {
+			value, ok := node.Labels[expr.Key]
+			if !ok || !containsString(expr.Values, value) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to find an available PV of the claim's class that is big enough,
+// supports the claim's access modes and is reachable from the node. PVs in
+// reserved are already taken by another claim of the pod.
+func findMatchingPV(snapshot *volumeSnapshot, pvc *v1.PersistentVolumeClaim, class *storagev1.StorageClass, node *v1.Node, reserved map[string]bool) *v1.PersistentVolume {
+	requested := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	claimMode := v1.PersistentVolumeFilesystem
+	if pvc.Spec.VolumeMode != nil {
+		claimMode = *pvc.Spec.VolumeMode
+	}
+	for _, pv := range snapshot.pvs {
+		if pv.Spec.ClaimRef != nil || pv.Status.Phase != v1.VolumeAvailable || reserved[pv.Name] {
+			continue
+		}
+		if pv.Spec.StorageClassName != class.Name {
+			continue
+		}
+		capacity := pv.Spec.Capacity[v1.ResourceStorage]
+		if capacity.Cmp(requested) < 0 {
+			continue
+		}
+		pvMode := v1.PersistentVolumeFilesystem
+		if pv.Spec.VolumeMode != nil {
+			pvMode = *pv.Spec.VolumeMode
+		}
+		if pvMode != claimMode || !containsAccessModes(pv.Spec.AccessModes, pvc.Spec.AccessModes) {
+			continue
+		}
+		if pvMatchesNode(pv, node) {
+			return pv
+		}
+	}
+	return nil
+}
+
+// Helper to check that every requested access mode is supported
+func containsAccessModes(supported, requested []v1.PersistentVolumeAccessMode) bool {
+	for _, mode := range requested {
+		found := false
+		for _, s := range supported {
+			if s == mode {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// NodeVolumeLimits filters out nodes where attaching the pod's CSI volumes
+// would exceed the per-driver attach limit of the node
+type NodeVolumeLimits struct{}
+
+func (p *NodeVolumeLimits) Name() string { return NodeVolumeLimitsName }
+
+// nodeVolumeLimitsState holds the volumes the pod would attach, per CSI driver
+type nodeVolumeLimitsState struct {
+	newVolumes map[string]map[string]bool
+	snapshot   *volumeSnapshot
+}
+
+func (p *NodeVolumeLimits) PreFilter(state *CycleState, pod *v1.Pod) error {
+	s := &nodeVolumeLimitsState{newVolumes: map[string]map[string]bool{}}
+	if len(getPodClaimNames(pod)) > 0 {
+		snapshot, err := getVolumeSnapshot(state)
+		if err != nil {
+			return err
+		}
+		s.snapshot = snapshot
+		s.newVolumes = getPodCSIVolumes(snapshot, pod)
+	}
+	state.Write(nodeVolumeLimitsPreFilterKey, s)
+	return nil
+}
+
+func (p *NodeVolumeLimits) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) error {
+	v, ok := state.Read(nodeVolumeLimitsPreFilterKey)
+	if !ok {
+		return fmt.Errorf("%s prefilter state not found", NodeVolumeLimitsName)
+	}
+	s := v.(*nodeVolumeLimitsState)
+	if len(s.newVolumes) == 0 {
+		return nil
+	}
+
+	attached := map[string]map[string]bool{}
+	for _, existing := range nodeInfo.Pods {
+		for driver, handles := range getPodCSIVolumes(s.snapshot, existing) {
+			if attached[driver] == nil {
+				attached[driver] = map[string]bool{}
+			}
+			for handle := range handles {
+				attached[driver][handle] = true
+			}
+		}
+	}
+
+	for driver, handles := range s.newVolumes {
+		limit, ok := getCSIAttachLimit(s.snapshot, nodeInfo.Node, driver)
+		if !ok {
+			continue
+		}
+		count := len(attached[driver])
+		for handle := range handles {
+			// Volumes shared with pods already on the node are attached once
+			if !attached[driver][handle] {
+				count++
+			}
+		}
+		if int64(count) > limit {
+			return fmt.Errorf("node(s) exceed max volume count")
+		}
+	}
+	return nil
+}
+
+// Helper to get the unique CSI volumes of a pod per driver. Unbound claims are
+// counted against the provisioner of their storage class.
+func getPodCSIVolumes(snapshot *volumeSnapshot, pod *v1.Pod) map[string]map[string]bool {
+	volumes := map[string]map[string]bool{}
+	add := func(driver, handle string) {
+		if volumes[driver] == nil {
+			volumes[driver] = map[string]bool{}
+		}
+		volumes[driver][handle] = true
+	}
+	for _, name := range getPodClaimNames(pod) {
+		pvc, ok := snapshot.pvcs[pod.Namespace+"/"+name]
+		if !ok {
+			continue
+		}
+		if pvc.Spec.VolumeName != "" {
+			if pv, ok := snapshot.pvs[pvc.Spec.VolumeName]; ok && pv.Spec.CSI != nil {
+				add(pv.Spec.CSI.Driver, pv.Spec.CSI.VolumeHandle)
+			}
+			continue
+		}
+		if class := snapshot.getClaimClass(pvc); class != nil && class.Provisioner != noProvisioner {
+			add(class.Provisioner, pvc.Namespace+"/"+pvc.Name)
+		}
+	}
+	return volumes
+}
+
+// Helper to get the attach limit of a CSI driver on a node from its CSINode,
+// falling back to the legacy attachable-volumes-csi-* allocatable
+func getCSIAttachLimit(snapshot *volumeSnapshot, node *v1.Node, driver string) (int64, bool) {
+	if csiNode, ok := snapshot.csiNodes[node.Name]; ok {
+		for _, d := range csiNode.Spec.Drivers {
+			if d.Name == driver && d.Allocatable != nil && d.Allocatable.Count != nil {
+				return int64(*d.Allocatable.Count), true
+			}
+		}
+	}
+	if limit, ok := node.Status.Allocatable[v1.ResourceName(attachableVolumesCSIPrefix+driver)]; ok {
+		return limit.Value(), true
+	}
+	return 0, false
+}